@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// clarificationTimeout is how long a session waits for the user to pick the
+// meaning of an ambiguous query before it continues with the planner's guess.
+const clarificationTimeout = 5 * time.Minute
+
+// clarifyQuery asks the user which meaning of the query is meant when the
+// planner found it ambiguous. If an option (or any other text) is chosen, it
+// replaces the query and the query is planned again; otherwise the plan for
+// the most likely meaning is kept.
+func (g *Server) clarifyQuery(ctx context.Context, s *Session) {
+	clarification := s.QueryPlan.Clarification
+	if clarification == nil {
+		return
+	}
+
+	s.awaitingChoice.Store(true)
+	s.Stream <- &Message{
+		Type:          MessageTypeClarification,
+		Clarification: clarification,
+	}
+
+	timer := time.NewTimer(clarificationTimeout)
+	defer timer.Stop()
+
+	var choice string
+	select {
+	case choice = <-s.choice:
+	case <-timer.C:
+		if s.awaitingChoice.CompareAndSwap(true, false) {
+			log.Info().Str("session", s.ID).Msg("No clarification chosen, keeping the query plan")
+		} else {
+			// A choice was submitted just in time.
+			choice = <-s.choice
+		}
+	}
+	s.QueryPlan.Clarification = nil
+	if choice == "" {
+		// Keep the plan, which may now be edited.
+		if s.PlanOnly {
+			s.awaitingPlan.Store(true)
+		}
+		s.Stream <- &Message{
+			Type:      MessageTypeQueryPlan,
+			QueryPlan: s.QueryPlan,
+			Degraded:  s.QueryPlanDegraded,
+			Paused:    s.PlanOnly,
+		}
+		return
+	}
+
+	log.Info().Str("query", s.Query).Str("choice", choice).Msg("Clarified query")
+	s.OriginalQuery = s.Query
+	s.Query = choice
+	g.planQuery(ctx, s)
+}
+
+// clarifyAPI continues a session waiting for a clarification with the chosen
+// query. An empty choice keeps the original query.
+func (g *Server) clarifyAPI(w http.ResponseWriter, r *http.Request) {
+	sessID := r.PathValue("sessID")
+	session := g.GetSession(sessID)
+	if session == nil {
+		http.Error(w, "{\"error\":\"session not found\"}", http.StatusNotFound)
+		return
+	}
+
+	type Choice struct {
+		Choice string `json:"choice"`
+	}
+
+	var c Choice
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !session.awaitingChoice.CompareAndSwap(true, false) {
+		http.Error(w, "{\"error\":\"session is not waiting for a clarification\"}", http.StatusConflict)
+		return
+	}
+	session.choice <- strings.TrimSpace(c.Choice)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{session.ID})
+}
@@ -29,4 +29,11 @@ func main() {
 	}
 
 	os.WriteFile("cmd/crawltest/index.text.txt", []byte(text), 0644)
+
+	markdown, err := htmldistill.ToMarkdown(html)
+	if err != nil {
+		panic(err)
+	}
+
+	os.WriteFile("cmd/crawltest/index.md", []byte(markdown), 0644)
 }
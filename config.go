@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"os"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/google/go-jsonnet"
+	"github.com/lemon-mint/infofluss/internal/reranker"
 	"gopkg.eu.org/envloader"
 )
 
@@ -35,11 +37,16 @@ func LoadConfig(file string) (*Config, error) {
 }
 
 type Config struct {
-	ModelConfigs    ModelConfigs   `json:"model_configs"`
-	CrawlerConfigs  CrawlerConfigs `json:"crawler_configs"`
-	Providers       []Providers    `json:"providers"`
-	SearchEngines   []string       `json:"search_engines"`
-	SearchEndpoints []string       `json:"search_endpoints"`
+	ModelConfigs     ModelConfigs     `json:"model_configs"`
+	CrawlerConfigs   CrawlerConfigs   `json:"crawler_configs"`
+	GeneratorConfigs GeneratorConfigs `json:"generator_configs"`
+	MultiHop         MultiHopConfigs  `json:"multi_hop"`
+	Research         ResearchConfigs  `json:"research"`
+	PlanCache        PlanCacheConfigs `json:"plan_cache"`
+	RerankerConfigs  RerankerConfigs  `json:"reranker_configs"`
+	Providers        []Providers      `json:"providers"`
+	SearchEngines    []string         `json:"search_engines"`
+	SearchEndpoints  []string         `json:"search_endpoints"`
 }
 
 type Parameters struct {
@@ -53,6 +60,17 @@ type ModelConfig struct {
 	Model      string     `json:"model"`
 	Parameters Parameters `json:"parameters"`
 	Provider   string     `json:"provider"`
+
+	// Type selects the implementation of the search reranker: "llm"
+	// (default) ranks with a chat prompt, "embedding" with the cosine
+	// similarity of embeddings from the provider, and "lexical" in-process
+	// without any model (provider and model are then unused).
+	Type string `json:"type,omitempty"`
+
+	// StructuredOutput overrides whether the model is asked for schema
+	// constrained replies (default: enabled for providers with native
+	// function calling).
+	StructuredOutput *bool `json:"structured_output,omitempty"`
 }
 
 type ModelConfigs struct {
@@ -72,5 +90,213 @@ type Providers struct {
 }
 
 type CrawlerConfigs struct {
-	Mode string `json:"mode"`
+	Mode           string `json:"mode"`
+	DocumentFormat string `json:"document_format,omitempty"` // html (default), markdown, text
+
+	// FollowLinks crawls the most relevant links of hub and index pages, one
+	// hop deep (up to MaxFollowLinks per page, default: 3).
+	FollowLinks    bool `json:"follow_links,omitempty"`
+	MaxFollowLinks int  `json:"max_follow_links,omitempty"`
+}
+
+type GeneratorConfigs struct {
+	// DocumentTokenBudget is the total number of estimated tokens of crawled
+	// documents sent to the response generator (default: chat.DefaultTokenBudget).
+	DocumentTokenBudget int `json:"document_token_budget,omitempty"`
+
+	// PassageRetrieval splits the documents into passages and only sends the
+	// passages most relevant to the query plan.
+	PassageRetrieval bool `json:"passage_retrieval,omitempty"`
+	// PassageTokens is the target size of a passage (default: passage.DefaultPassageTokens).
+	PassageTokens int `json:"passage_tokens,omitempty"`
+
+	// ContentRerank ranks the crawled pages again by their content before
+	// they are sent to the response generator.
+	ContentRerank ContentRerankConfigs `json:"content_rerank"`
+}
+
+type ContentRerankConfigs struct {
+	// Enabled reorders the crawled pages with the search reranker, reading
+	// their text instead of the search snippets, and drops empty pages and
+	// pages the reranker leaves out.
+	Enabled bool `json:"enabled,omitempty"`
+	// ExcerptTokens is how much of every page the reranker reads (default: 1000).
+	ExcerptTokens int `json:"excerpt_tokens,omitempty"`
+	// MinTokens is the size of text below which a page is dropped as empty
+	// (default: 30).
+	MinTokens int `json:"min_tokens,omitempty"`
+	// MinScore drops pages the reranker scores below it (default: 0, keep
+	// all); see RerankOptions.MinScore for the scales.
+	MinScore float64 `json:"min_score,omitempty"`
+}
+
+type MultiHopConfigs struct {
+	// Enabled runs search queries that depend on the findings of earlier
+	// queries in hops, rewriting them before they are run. It can be
+	// overridden per request.
+	Enabled bool `json:"enabled,omitempty"`
+	// MaxHops is the maximum number of search rounds (default: 3).
+	MaxHops int `json:"max_hops,omitempty"`
+}
+
+// ResearchConfigs sets the budgets of deep research sessions. Zero values use
+// the defaults of the research package.
+type ResearchConfigs struct {
+	MaxRounds       int `json:"max_rounds,omitempty"`        // plan-search-read rounds (default: 3)
+	MaxPages        int `json:"max_pages,omitempty"`         // pages crawled over all rounds (default: 30)
+	QueriesPerRound int `json:"queries_per_round,omitempty"` // search queries of follow-up rounds (default: 4)
+
+	RoundTokenBudget   int `json:"round_token_budget,omitempty"`   // tokens of passages read per round (default: 32000)
+	NotesTokens        int `json:"notes_tokens,omitempty"`         // size the notes are kept under (default: 8000)
+	SectionTokenBudget int `json:"section_token_budget,omitempty"` // tokens of passages per report section (default: 16000)
+	MaxSections        int `json:"max_sections,omitempty"`         // sections of the report (default: 6)
+}
+
+type PlanCacheConfigs struct {
+	// Enabled reuses the query plans of identical or near-identical queries.
+	// Time-sensitive plans are never cached.
+	Enabled bool `json:"enabled,omitempty"`
+	// Size is the number of plans kept in memory (default: 1024).
+	Size int `json:"size,omitempty"`
+	// TTLSeconds is how long a plan is reused (default: one day).
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	// Dir, if set, also stores the plans on disk.
+	Dir string `json:"dir,omitempty"`
+}
+
+type RerankerConfigs struct {
+	// Fallback is the order used when reranking fails: "lexical" (default)
+	// ranks the results by BM25 against the search query, "engine" keeps the
+	// order of the search engine.
+	Fallback string `json:"fallback,omitempty"`
+
+	// DomainTrust maps domains (and their subdomains) to score multipliers
+	// of the lexical reranker, which is also used for the fallback order.
+	DomainTrust map[string]float64 `json:"domain_trust,omitempty"`
+
+	// PreFilter narrows candidate sets larger than this down with the
+	// lexical reranker before the model-based reranker sees them (0 to
+	// disable).
+	PreFilter int `json:"pre_filter,omitempty"`
+
+	// BatchTokens is the token budget of the candidates in one prompt of the
+	// llm reranker (default: 16000; -1 to send all candidates at once).
+	// Larger candidate sets are ranked in a tournament of windows that fit
+	// the budget, and the page excerpts of content reranking in windows that
+	// are merged. The model config does not know the context size of the
+	// model, so it is not derived from it: set it well below that size.
+	BatchTokens int `json:"batch_tokens,omitempty"`
+
+	// MaxResults caps the number of results a request can ask for per
+	// search query (default: 10, or Results if larger).
+	MaxResults int `json:"max_results,omitempty"`
+
+	// The deployment defaults of the selection, which requests can override.
+	RerankOptions
+}
+
+// RerankOptions control which search results are selected for crawling.
+type RerankOptions struct {
+	// Results is the number of results selected per search query (default:
+	// 4). Requests can ask for up to RerankerConfigs.MaxResults.
+	Results int `json:"results,omitempty"`
+
+	// AvoidFileTypes are file extensions ranked last (default: ["pdf"]; []
+	// avoids nothing). PreferFileTypes are file extensions ranked first.
+	AvoidFileTypes  []string `json:"avoid_file_types,omitempty"`
+	PreferFileTypes []string `json:"prefer_file_types,omitempty"`
+
+	// Criteria are added to the relevance criteria of the prompt-based
+	// reranker, e.g. "Prefer peer-reviewed sources.". They are pasted into
+	// the prompt: requests can set up to 5 criteria of up to 200 bytes.
+	Criteria []string `json:"criteria,omitempty"`
+
+	// Diversity, between 0 and 1, penalizes selecting several results from
+	// the same domain (default: 0.3; 0 to disable).
+	Diversity *float64 `json:"diversity,omitempty"`
+
+	// MinScore drops results the reranker scores below it (default: 0, keep
+	// all). The scale depends on the reranker: 0 to 1 for llm and rerank
+	// services, cosine similarity for embedding, and the share of the best
+	// score for lexical. Results ranked by the fallback order are kept.
+	MinScore *float64 `json:"min_score,omitempty"`
+}
+
+// Limits on the selection options of requests.
+const (
+	defaultMaxRerankResults = 10
+	maxRerankCriteria       = 5
+	maxRerankCriterionBytes = 200
+)
+
+// options returns the reranker options of the deployment, with the fields
+// set in override taking precedence. override comes from the request and is
+// untrusted: its Results is capped at MaxResults, and only the first few of
+// its Criteria are kept, on a single line and cut to a short length, since
+// they are pasted into the reranker prompt.
+func (c *RerankerConfigs) options(override *RerankOptions) *reranker.Options {
+	opts := &reranker.Options{
+		Results:         c.Results,
+		AvoidFileTypes:  c.AvoidFileTypes,
+		PreferFileTypes: c.PreferFileTypes,
+		Criteria:        c.Criteria,
+		Diversity:       reranker.DefaultDiversity,
+	}
+	if c.Diversity != nil {
+		opts.Diversity = *c.Diversity
+	}
+	if c.MinScore != nil {
+		opts.MinScore = *c.MinScore
+	}
+
+	if override != nil {
+		if override.Results > 0 {
+			maxResults := c.MaxResults
+			if maxResults <= 0 {
+				maxResults = max(defaultMaxRerankResults, c.Results)
+			}
+			opts.Results = min(override.Results, maxResults)
+		}
+		if override.AvoidFileTypes != nil {
+			opts.AvoidFileTypes = override.AvoidFileTypes
+		}
+		if override.PreferFileTypes != nil {
+			opts.PreferFileTypes = override.PreferFileTypes
+		}
+		if override.Criteria != nil {
+			opts.Criteria = requestCriteria(override.Criteria)
+		}
+		if override.Diversity != nil {
+			opts.Diversity = *override.Diversity
+		}
+		if override.MinScore != nil {
+			opts.MinScore = *override.MinScore
+		}
+	}
+	opts.Diversity = min(max(opts.Diversity, 0), 1)
+	return opts
+}
+
+// requestCriteria returns the first maxRerankCriteria non-empty criteria of
+// a request, with their whitespace collapsed and cut to
+// maxRerankCriterionBytes.
+func requestCriteria(criteria []string) []string {
+	result := make([]string, 0, min(len(criteria), maxRerankCriteria))
+	for _, criterion := range criteria {
+		if len(result) == maxRerankCriteria {
+			break
+		}
+		criterion = strings.Join(strings.Fields(criterion), " ")
+		if len(criterion) > maxRerankCriterionBytes {
+			n := maxRerankCriterionBytes
+			for n > 0 && !utf8.RuneStart(criterion[n]) {
+				n--
+			}
+			criterion = criterion[:n]
+		}
+		if criterion != "" {
+			result = append(result, criterion)
+		}
+	}
+	return result
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/infofluss/internal/reranker"
+)
+
+func TestRerankOptions(t *testing.T) {
+	diversity, minScore := 0.5, 0.2
+	tooDiverse := 3.0
+
+	tests := []struct {
+		name     string
+		config   RerankerConfigs
+		override *RerankOptions
+		want     *reranker.Options
+	}{
+		{
+			name: "defaults",
+			want: &reranker.Options{Diversity: reranker.DefaultDiversity},
+		},
+		{
+			name: "deployment options",
+			config: RerankerConfigs{RerankOptions: RerankOptions{
+				Results: 6, AvoidFileTypes: []string{}, Criteria: []string{"Prefer primary sources."},
+				Diversity: &diversity, MinScore: &minScore,
+			}},
+			want: &reranker.Options{
+				Results: 6, AvoidFileTypes: []string{}, Criteria: []string{"Prefer primary sources."},
+				Diversity: 0.5, MinScore: 0.2,
+			},
+		},
+		{
+			name:     "request overrides",
+			config:   RerankerConfigs{RerankOptions: RerankOptions{Results: 4, Criteria: []string{"a"}}},
+			override: &RerankOptions{Results: 8, PreferFileTypes: []string{"html"}, Criteria: []string{}, Diversity: &tooDiverse},
+			want:     &reranker.Options{Results: 8, PreferFileTypes: []string{"html"}, Criteria: []string{}, Diversity: 1},
+		},
+		{
+			name:     "request results capped at the default maximum",
+			override: &RerankOptions{Results: 1000},
+			want:     &reranker.Options{Results: defaultMaxRerankResults, Diversity: reranker.DefaultDiversity},
+		},
+		{
+			name:     "default maximum raised to the deployment results",
+			config:   RerankerConfigs{RerankOptions: RerankOptions{Results: 20}},
+			override: &RerankOptions{Results: 1000},
+			want:     &reranker.Options{Results: 20, Diversity: reranker.DefaultDiversity},
+		},
+		{
+			name:     "request results capped at MaxResults",
+			config:   RerankerConfigs{MaxResults: 5},
+			override: &RerankOptions{Results: 6},
+			want:     &reranker.Options{Results: 5, Diversity: reranker.DefaultDiversity},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.options(tt.override); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("options() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestCriteria(t *testing.T) {
+	long := strings.Repeat("가", maxRerankCriterionBytes) // 3 bytes per rune
+
+	tests := []struct {
+		name     string
+		criteria []string
+		want     []string
+	}{
+		{"whitespace collapsed", []string{"  Prefer\nofficial\t docs. ", " ", ""}, []string{"Prefer official docs."}},
+		{"count capped", []string{"1", "2", "", "3", "4", "5", "6"}, []string{"1", "2", "3", "4", "5"}},
+		{"length capped at a rune boundary", []string{long}, []string{long[:maxRerankCriterionBytes/3*3]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestCriteria(tt.criteria); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestCriteria() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/lemon-mint/infofluss/internal/bm25"
+	"github.com/lemon-mint/infofluss/internal/htmldistill"
+)
+
+const defaultMaxFollowLinks = 3
+
+// selectLinks returns up to limit links of a hub page that are the most
+// relevant to queries. Links are scored with BM25 over their anchor text and
+// the words of their path; navigation links are never followed.
+func selectLinks(inv *htmldistill.Inventory, queries []string, limit int) []string {
+	if limit <= 0 {
+		limit = defaultMaxFollowLinks
+	}
+
+	var candidates []htmldistill.Link
+	var texts []string
+	for _, link := range inv.Links {
+		if link.Navigation || link.Text == "" {
+			continue
+		}
+		text := link.Text
+		if u, err := url.Parse(link.URL); err == nil {
+			text += " " + strings.NewReplacer("/", " ", "-", " ", "_", " ", ".", " ").Replace(u.Path)
+		}
+		candidates = append(candidates, link)
+		texts = append(texts, text)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	index := bm25.New(texts)
+	scores := make([]float64, len(candidates))
+	for _, query := range queries {
+		for i, score := range index.Score(query) {
+			scores[i] = max(scores[i], score)
+		}
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	var links []string
+	for _, i := range order {
+		if scores[i] <= 0 || len(links) >= limit {
+			break
+		}
+		links = append(links, candidates[i].URL)
+	}
+	return links
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lemon-mint/infofluss/internal/htmldistill"
+)
+
+func TestSelectLinks(t *testing.T) {
+	inv := &htmldistill.Inventory{Links: []htmldistill.Link{
+		{URL: "https://example.com/about", Text: "About us"},
+		{URL: "https://example.com/go-generics-tutorial", Text: "Tutorial"},
+		{URL: "https://example.com/nav/go-generics", Text: "Go generics", Navigation: true},
+		{URL: "https://example.com/posts/1", Text: "Go generics explained"},
+		{URL: "https://example.com/posts/2", Text: "Go release notes"},
+		{URL: "https://example.com/go-generics"},
+		{URL: "https://example.com/rust", Text: "Rust traits"},
+	}}
+
+	tests := []struct {
+		name    string
+		queries []string
+		limit   int
+		want    []string
+	}{
+		{
+			name:    "ranked by anchor text and path",
+			queries: []string{"go generics"},
+			limit:   10,
+			want: []string{
+				"https://example.com/go-generics-tutorial",
+				"https://example.com/posts/1",
+				"https://example.com/posts/2",
+			},
+		},
+		{
+			name:    "limit",
+			queries: []string{"go generics"},
+			limit:   1,
+			want:    []string{"https://example.com/go-generics-tutorial"},
+		},
+		{
+			name:    "best score over the queries",
+			queries: []string{"go generics", "rust traits"},
+			limit:   2,
+			want:    []string{"https://example.com/rust", "https://example.com/go-generics-tutorial"},
+		},
+		{
+			name:    "no match",
+			queries: []string{"python"},
+			limit:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectLinks(inv, tt.queries, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectLinks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The default limit applies without one.
+	many := &htmldistill.Inventory{}
+	for i := 0; i < 10; i++ {
+		many.Links = append(many.Links, htmldistill.Link{URL: "https://example.com/" + string(rune('a'+i)), Text: "go"})
+	}
+	if got := selectLinks(many, []string{"go"}, 0); len(got) != defaultMaxFollowLinks {
+		t.Errorf("selectLinks() without a limit = %d links, want %d", len(got), defaultMaxFollowLinks)
+	}
+}
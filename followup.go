@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/followup"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// conversationTTL is how long a finished session can be followed up.
+	conversationTTL = 30 * time.Minute
+	// maxConversations bounds the number of finished sessions kept, with
+	// their crawled pages, for follow-ups.
+	maxConversations = 256
+)
+
+type conversation struct {
+	session *Session
+	expires time.Time
+}
+
+// keepConversation keeps a finished session that produced an answer for
+// follow-ups, dropping expired conversations and, if there are too many,
+// the oldest. The sessions mutex must be held.
+func (g *Server) keepConversation(s *Session) {
+	if s.Answer() == "" {
+		return
+	}
+
+	now := time.Now()
+	for id, c := range g.conversations {
+		if now.After(c.expires) {
+			delete(g.conversations, id)
+		}
+	}
+	for len(g.conversations) >= maxConversations {
+		var oldest string
+		for id, c := range g.conversations {
+			if oldest == "" || c.expires.Before(g.conversations[oldest].expires) {
+				oldest = id
+			}
+		}
+		delete(g.conversations, oldest)
+	}
+
+	g.conversations[s.ID] = &conversation{session: s, expires: now.Add(conversationTTL)}
+}
+
+// getConversation returns the finished session with the given ID, if it can
+// still be followed up.
+func (g *Server) getConversation(id string) *Session {
+	g.sessionsMutex.Lock()
+	defer g.sessionsMutex.Unlock()
+	c, ok := g.conversations[id]
+	if !ok || time.Now().After(c.expires) {
+		return nil
+	}
+	return c.session
+}
+
+// followUpAPI starts a session answering a follow-up question to a finished
+// session. The new session continues the conversation: it has the earlier
+// turns and crawled pages, and is streamed like a search session.
+func (g *Server) followUpAPI(w http.ResponseWriter, r *http.Request) {
+	sessID := r.PathValue("sessID")
+	previous := g.getConversation(sessID)
+	if previous == nil {
+		if g.GetSession(sessID) != nil {
+			http.Error(w, "{\"error\":\"session has not finished\"}", http.StatusConflict)
+			return
+		}
+		http.Error(w, "{\"error\":\"session not found\"}", http.StatusNotFound)
+		return
+	}
+
+	type Query struct {
+		Query string `json:"query"`
+	}
+
+	var q Query
+	err := json.NewDecoder(r.Body).Decode(&q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q.Query = strings.TrimSpace(q.Query)
+	if q.Query == "" {
+		http.Error(w, "{\"error\":\"empty query\"}", http.StatusBadRequest)
+		return
+	}
+
+	session := g.NewSession(q.Query)
+	session.History = append(slices.Clip(previous.History), chat.Turn{
+		Query:  previous.Query,
+		Answer: previous.Answer(),
+	})
+	session.CrawledPages = maps.Clone(previous.CrawledPages)
+	session.Sources = slices.Clone(previous.Sources)
+	session.RerankOptions = previous.RerankOptions
+	session.MultiHop = previous.MultiHop
+	go g.followUpWorker(session)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{session.ID})
+}
+
+// joinedQuery returns the query that started the conversation of s followed by
+// the follow-up question. It stands in for the rewritten question when the
+// chat model cannot rewrite it, since the follow-up alone is often a fragment
+// (e.g. "and in Rust?") that searches poorly.
+func joinedQuery(s *Session) string {
+	if len(s.History) == 0 {
+		return s.Query
+	}
+	return s.History[0].Query + " " + s.Query
+}
+
+// followUpWorker answers a follow-up question with the chat model. The model
+// first decides whether the pages read for the conversation are enough; if
+// not, the rewritten question is planned and searched like a new query, and
+// the new pages are added after the earlier ones.
+func (g *Server) followUpWorker(s *Session) {
+	defer g.CloseSession(s.ID)
+
+	ctx := context.Background()
+	urls := s.Sources
+
+	decision, err := followup.Decide(ctx, g.models["chat"], s.History, s.Query, s.Sources)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decide on follow-up, answering from the read pages")
+		decision = &followup.Decision{Query: joinedQuery(s)}
+	}
+	log.Info().Str("query", s.Query).Interface("decision", decision).Msg("Follow-up decision")
+
+	if decision.Search || len(urls) == 0 {
+		s.Standalone = decision.Query
+		g.planQuery(ctx, s)
+
+		if s.MultiHop && s.QueryPlan.HasDependencies() {
+			g.multiHopSearch(ctx, s)
+		} else {
+			var indexes []int = make([]int, len(s.QueryPlan.SearchQueries))
+			for i := range indexes {
+				indexes[i] = i
+			}
+			g.searchQueries(ctx, s, indexes)
+			g.crawlResults(s, indexes, 0)
+		}
+		urls = append(slices.Clip(urls), s.rankedURLs()...)
+	}
+
+	documents := g.prepareDocuments(ctx, s, urls)
+	response := chat.GenerateFollowUp(ctx, g.models["chat"], s.History, s.Query, s.QueryPlan, documents)
+	if !g.streamResponse(s, time.Now(), response) {
+		return
+	}
+
+	s.finishAnswer()
+}
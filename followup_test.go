@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lemon-mint/infofluss/internal/chat"
+)
+
+func TestJoinedQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []chat.Turn
+		want    string
+	}{
+		{name: "no history", want: "and in Rust?"},
+		{
+			name:    "first turn",
+			history: []chat.Turn{{Query: "Go generics performance"}, {Query: "what about Java?"}},
+			want:    "Go generics performance and in Rust?",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{Query: "and in Rust?", History: tt.history}
+			if got := joinedQuery(s); got != tt.want {
+				t.Errorf("joinedQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ require (
 	github.com/lemon-mint/envaddr v0.0.0-20220724120637-f2bfd2710deb
 	github.com/rs/zerolog v1.33.0
 	golang.org/x/net v0.28.0
+	golang.org/x/text v0.17.0
 	gopkg.eu.org/envloader v1.1.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -57,7 +58,6 @@ require (
 	golang.org/x/oauth2 v0.22.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/sys v0.23.0 // indirect
-	golang.org/x/text v0.17.0 // indirect
 	golang.org/x/time v0.6.0 // indirect
 	google.golang.org/api v0.190.0 // indirect
 	google.golang.org/genproto v0.0.0-20240805194559-2c9e96a0b5d4 // indirect
@@ -0,0 +1,155 @@
+package bm25
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultK1 = 1.2
+	DefaultB  = 0.75
+)
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "how": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "what": true, "when": true,
+	"where": true, "which": true, "who": true, "why": true, "with": true,
+}
+
+func isCJK(r rune) bool {
+	// The prolonged sound mark (ー) is in the Common script but is part of
+	// Japanese words.
+	return r == 'ー' || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Tokenize splits s into lowercase terms. Words are split on anything that is
+// not a letter or a digit, and runs of CJK characters are turned into
+// overlapping bigrams since they are not separated by spaces.
+func Tokenize(s string) []string {
+	var terms []string
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			w := string(word)
+			if !stopwords[w] {
+				terms = append(terms, w)
+			}
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			terms = append(terms, string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				terms = append(terms, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return terms
+}
+
+// Index is an Okapi BM25 index over a fixed set of documents.
+type Index struct {
+	K1 float64
+	B  float64
+
+	tf     []map[string]int
+	length []int
+	df     map[string]int
+	avgdl  float64
+}
+
+// New builds an index over documents.
+func New(documents []string) *Index {
+	x := &Index{
+		K1:     DefaultK1,
+		B:      DefaultB,
+		tf:     make([]map[string]int, len(documents)),
+		length: make([]int, len(documents)),
+		df:     make(map[string]int),
+	}
+
+	var total int
+	for i, doc := range documents {
+		terms := Tokenize(doc)
+		tf := make(map[string]int, len(terms))
+		for _, term := range terms {
+			tf[term]++
+		}
+		for term := range tf {
+			x.df[term]++
+		}
+		x.tf[i] = tf
+		x.length[i] = len(terms)
+		total += len(terms)
+	}
+	if len(documents) > 0 {
+		x.avgdl = float64(total) / float64(len(documents))
+	}
+
+	return x
+}
+
+// Len returns the number of documents in the index.
+func (x *Index) Len() int {
+	return len(x.tf)
+}
+
+// Score returns the BM25 score of every document for query.
+func (x *Index) Score(query string) []float64 {
+	scores := make([]float64, len(x.tf))
+	if x.avgdl == 0 {
+		return scores
+	}
+
+	n := float64(len(x.tf))
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		df := float64(x.df[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for i, tf := range x.tf {
+			f := float64(tf[term])
+			if f == 0 {
+				continue
+			}
+			norm := 1 - x.B + x.B*float64(x.length[i])/x.avgdl
+			scores[i] += idf * f * (x.K1 + 1) / (f + x.K1*norm)
+		}
+	}
+
+	return scores
+}
@@ -0,0 +1,71 @@
+package bm25
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"What is the Go garbage-collector?", []string{"go", "garbage", "collector"}},
+		{"HTTP/2 in 2024", []string{"http", "2", "2024"}},
+		{"한국어 검색", []string{"한국", "국어", "검색"}},
+		{"東京タワー", []string{"東京", "京タ", "タワ", "ワー"}},
+		{"Go言語", []string{"go", "言語"}},
+		{"字", []string{"字"}},
+		{"Ünïcode café", []string{"ünïcode", "café"}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	index := New([]string{
+		"The Go garbage collector is a concurrent mark and sweep collector.",
+		"Rust has no garbage collector and uses ownership instead.",
+		"Python is a dynamically typed language.",
+		"Go Go Go: a short history of the Go language and its collector design, its scheduler, its toolchain, and its standard library.",
+	})
+	if index.Len() != 4 {
+		t.Fatalf("Len() = %d, want 4", index.Len())
+	}
+
+	tests := []struct {
+		query string
+		best  int
+		zero  []int
+	}{
+		{"go garbage collector", 0, []int{2}},
+		{"ownership", 1, []int{0, 2, 3}},
+		{"python language", 2, []int{0, 1}},
+	}
+	for _, tt := range tests {
+		scores := index.Score(tt.query)
+		for i, s := range scores {
+			if i != tt.best && s >= scores[tt.best] {
+				t.Errorf("Score(%q): document %d scored %v, not below the best document %d (%v)", tt.query, i, s, tt.best, scores[tt.best])
+			}
+		}
+		for _, i := range tt.zero {
+			if scores[i] != 0 {
+				t.Errorf("Score(%q): document %d scored %v, want 0", tt.query, i, scores[i])
+			}
+		}
+	}
+}
+
+func TestScoreEmpty(t *testing.T) {
+	if got := New(nil).Score("anything"); len(got) != 0 {
+		t.Errorf("Score() on empty index = %v", got)
+	}
+	if got := New([]string{"some text"}).Score("the"); !reflect.DeepEqual(got, []float64{0}) {
+		t.Errorf("Score() of a stop word = %v, want [0]", got)
+	}
+}
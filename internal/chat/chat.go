@@ -2,10 +2,13 @@ package chat
 
 import (
 	"context"
+	"html"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/htmldistill"
 	"github.com/lemon-mint/infofluss/internal/queryplan"
 )
 
@@ -31,12 +34,76 @@ Make active use of Markdown tables, bolding, italics, and links.
 
 All responses should begin with a Markdown heading.`
 
+// DocumentFormat describes how the textual contents of a Document are encoded.
+type DocumentFormat string
+
+const (
+	DocumentFormatHTML     DocumentFormat = "html"
+	DocumentFormatMarkdown DocumentFormat = "markdown"
+	DocumentFormatText     DocumentFormat = "text"
+)
+
 type Document struct {
 	Source   string
+	Format   DocumentFormat
+	Language string
+	Metadata *htmldistill.PageMetadata
 	Contents []llm.Segment
 }
 
+func writeMetadata(sb *strings.Builder, md *htmldistill.PageMetadata) {
+	if md == nil {
+		return
+	}
+
+	fields := []struct {
+		tag   string
+		value string
+	}{
+		{"title", md.Title},
+		{"site_name", md.SiteName},
+		{"author", md.Author},
+		{"published_time", md.PublishedTime},
+		{"modified_time", md.ModifiedTime},
+		{"canonical_url", md.CanonicalURL},
+	}
+
+	written := false
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		if !written {
+			sb.WriteString("<metadata>\n")
+			written = true
+		}
+		// The values come from the page; escape them so that they cannot
+		// close the document framing.
+		sb.WriteString("<" + f.tag + ">" + html.EscapeString(f.value) + "</" + f.tag + ">\n")
+	}
+	if written {
+		sb.WriteString("</metadata>\n")
+	}
+}
+
 func Generate(ctx context.Context, m llm.Model, query string, queryplan *queryplan.QueryPlan, documents []Document) *llm.StreamContent {
+	return GenerateFollowUp(ctx, m, nil, query, queryplan, documents)
+}
+
+// Turn is a question of a conversation and its answer.
+type Turn struct {
+	Query  string `json:"query"`
+	Answer string `json:"answer"`
+}
+
+// citationRe matches the source markers of an answer.
+var citationRe = regexp.MustCompile(`§\[[\d,\s]*\]`)
+
+// GenerateFollowUp answers query like Generate, after the earlier turns of
+// the conversation in history, oldest first. queryplan may be nil when no
+// search was run for the query. The source markers are removed from earlier
+// answers, as their document numbers no longer apply.
+func GenerateFollowUp(ctx context.Context, m llm.Model, history []Turn, query string, queryplan *queryplan.QueryPlan, documents []Document) *llm.StreamContent {
 	var parts []llm.Segment
 	var sb strings.Builder
 
@@ -44,21 +111,61 @@ func Generate(ctx context.Context, m llm.Model, query string, queryplan *querypl
 	sb.WriteString("<query>\n")
 	sb.WriteString(query)
 	sb.WriteString("</query>\n")
-	sb.WriteString("<instructions>\n")
-	sb.WriteString(queryplan.Instruction)
-	sb.WriteString("</instructions>\n")
+	if queryplan != nil {
+		sb.WriteString("<instructions>\n")
+		sb.WriteString(queryplan.Instruction)
+		sb.WriteString("</instructions>\n")
+	}
 	sb.WriteString("</user_query>\n\n")
 	parts = append(parts, llm.Text(sb.String()))
 	sb.Reset()
 
+	parts = append(parts, DocumentParts(documents, nil)...)
+
+	contents := make([]*llm.Content, 0, 2*len(history))
+	for _, turn := range history {
+		contents = append(contents,
+			llm.TextContent(llm.RoleUser, turn.Query),
+			llm.TextContent(llm.RoleModel, citationRe.ReplaceAllString(turn.Answer, "")),
+		)
+	}
+
+	return m.GenerateStream(ctx, &llm.ChatContext{
+		SystemInstruction: prompt,
+		Contents:          contents,
+	}, &llm.Content{
+		Role:  llm.RoleUser,
+		Parts: parts,
+	})
+}
+
+// DocumentParts renders documents as the <documents> block of a prompt. Each
+// document is numbered with the matching entry of indexes, or with its
+// position (starting at 1) if indexes is nil; the numbers are the ones
+// citations refer to.
+func DocumentParts(documents []Document, indexes []int) []llm.Segment {
+	var parts []llm.Segment
+	var sb strings.Builder
+
 	sb.WriteString("<documents>\n")
 	for i, doc := range documents {
 		sb.WriteString("<document>\n")
-		sb.WriteString("<index>" + strconv.Itoa(i+1) + "</index>\n")
+		index := i + 1
+		if indexes != nil {
+			index = indexes[i]
+		}
+		sb.WriteString("<index>" + strconv.Itoa(index) + "</index>\n")
 		sb.WriteString("<source>\n")
 		sb.WriteString(doc.Source)
 		sb.WriteString("\n")
 		sb.WriteString("</source>\n")
+		writeMetadata(&sb, doc.Metadata)
+		if doc.Language != "" {
+			sb.WriteString("<language>" + doc.Language + "</language>\n")
+		}
+		if doc.Format != "" {
+			sb.WriteString("<format>" + string(doc.Format) + "</format>\n")
+		}
 		sb.WriteString("<content>\n")
 		parts = append(parts, llm.Text(sb.String()))
 		sb.Reset()
@@ -73,10 +180,5 @@ func Generate(ctx context.Context, m llm.Model, query string, queryplan *querypl
 	parts = append(parts, llm.Text(sb.String()))
 	sb.Reset()
 
-	return m.GenerateStream(ctx, &llm.ChatContext{
-		SystemInstruction: prompt,
-	}, &llm.Content{
-		Role:  llm.RoleUser,
-		Parts: parts,
-	})
+	return parts
 }
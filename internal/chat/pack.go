@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/tokens"
+)
+
+// DefaultTokenBudget is the total number of document tokens used by Pack when
+// no budget is configured.
+const DefaultTokenBudget = 64000
+
+// minDocumentTokens is the smallest allocation worth sending to the model.
+// Documents that would get less than this are dropped instead of truncated.
+const minDocumentTokens = 256
+
+const truncatedMarker = "\n\n[... truncated]"
+
+type PackStatus string
+
+const (
+	PackStatusKept      PackStatus = "kept"
+	PackStatusTruncated PackStatus = "truncated"
+	PackStatusDropped   PackStatus = "dropped"
+)
+
+// PackReport records what happened to a document during packing.
+type PackReport struct {
+	Source     string     `json:"source"`
+	Status     PackStatus `json:"status"`
+	Tokens     int        `json:"tokens"`      // estimated tokens of the original document
+	KeptTokens int        `json:"kept_tokens"` // estimated tokens sent to the model
+}
+
+// Pack fits documents into a total token budget.
+//
+// Documents must be ordered by relevance (rerank position). The budget is
+// split by position with weights 1, 1/2, 1/3, ... and any share a document
+// does not need is redistributed to the remaining ones, so that short
+// documents are kept whole and long documents at the tail are truncated or
+// dropped first.
+func Pack(documents []Document, budget int) ([]Document, []PackReport) {
+	if budget <= 0 {
+		budget = DefaultTokenBudget
+	}
+
+	need := make([]int, len(documents))
+	alloc := make([]int, len(documents))
+	active := make([]bool, len(documents))
+	for i := range documents {
+		need[i] = tokens.EstimateSegments(documents[i].Contents)
+		active[i] = true
+	}
+
+	remaining := budget
+	for {
+		var weights float64
+		for i := range documents {
+			if active[i] {
+				weights += 1 / float64(i+1)
+			}
+		}
+		if weights == 0 {
+			break
+		}
+
+		satisfied := false
+		for i := range documents {
+			if !active[i] {
+				continue
+			}
+			share := int(float64(remaining) * (1 / float64(i+1)) / weights)
+			if need[i] <= share {
+				alloc[i] = need[i]
+				remaining -= need[i]
+				active[i] = false
+				satisfied = true
+			}
+		}
+		if satisfied {
+			continue
+		}
+
+		for i := range documents {
+			if active[i] {
+				alloc[i] = int(float64(remaining) * (1 / float64(i+1)) / weights)
+				active[i] = false
+			}
+		}
+		break
+	}
+
+	packed := make([]Document, 0, len(documents))
+	reports := make([]PackReport, len(documents))
+	for i, doc := range documents {
+		reports[i] = PackReport{
+			Source: doc.Source,
+			Tokens: need[i],
+		}
+
+		switch {
+		case alloc[i] >= need[i]:
+			reports[i].Status = PackStatusKept
+			reports[i].KeptTokens = need[i]
+			packed = append(packed, doc)
+		case alloc[i] < minDocumentTokens:
+			reports[i].Status = PackStatusDropped
+		default:
+			doc.Contents = truncateSegments(doc.Contents, alloc[i])
+			reports[i].KeptTokens = tokens.EstimateSegments(doc.Contents)
+			if len(doc.Contents) == 0 {
+				reports[i].Status = PackStatusDropped
+				continue
+			}
+			reports[i].Status = PackStatusTruncated
+			packed = append(packed, doc)
+		}
+	}
+
+	return packed, reports
+}
+
+func truncateSegments(parts []llm.Segment, limit int) []llm.Segment {
+	var truncated []llm.Segment
+	for _, part := range parts {
+		cost := tokens.EstimateSegments([]llm.Segment{part})
+		if cost <= limit {
+			truncated = append(truncated, part)
+			limit -= cost
+			continue
+		}
+
+		if text, ok := part.(llm.Text); ok && limit >= minDocumentTokens/2 {
+			truncated = append(truncated, llm.Text(tokens.Truncate(string(text), limit)+truncatedMarker))
+		}
+		break
+	}
+	return truncated
+}
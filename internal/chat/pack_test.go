@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+)
+
+// textDocument returns a document whose contents are estimated at n tokens.
+func textDocument(source string, n int) Document {
+	return Document{
+		Source:   source,
+		Contents: []llm.Segment{llm.Text(strings.Repeat("a", 4*n))},
+	}
+}
+
+func TestPack(t *testing.T) {
+	tests := []struct {
+		name      string
+		documents []Document
+		budget    int
+		want      []PackReport
+	}{
+		{
+			name:      "everything fits",
+			documents: []Document{textDocument("a", 300), textDocument("b", 300)},
+			budget:    1000,
+			want: []PackReport{
+				{Source: "a", Status: PackStatusKept, Tokens: 300, KeptTokens: 300},
+				{Source: "b", Status: PackStatusKept, Tokens: 300, KeptTokens: 300},
+			},
+		},
+		{
+			name:      "default budget",
+			documents: []Document{textDocument("a", DefaultTokenBudget/2), textDocument("b", DefaultTokenBudget/4)},
+			want: []PackReport{
+				{Source: "a", Status: PackStatusKept, Tokens: DefaultTokenBudget / 2, KeptTokens: DefaultTokenBudget / 2},
+				{Source: "b", Status: PackStatusKept, Tokens: DefaultTokenBudget / 4, KeptTokens: DefaultTokenBudget / 4},
+			},
+		},
+		{
+			name:      "unused share is redistributed",
+			documents: []Document{textDocument("a", 800), textDocument("b", 100)},
+			budget:    1000,
+			want: []PackReport{
+				{Source: "a", Status: PackStatusKept, Tokens: 800, KeptTokens: 800},
+				{Source: "b", Status: PackStatusKept, Tokens: 100, KeptTokens: 100},
+			},
+		},
+		{
+			name:      "split by position",
+			documents: []Document{textDocument("a", 900), textDocument("b", 900), textDocument("c", 900)},
+			budget:    1000,
+			// Shares of 545, 272 and 181 tokens; the truncation marker adds
+			// a few tokens to the truncated documents.
+			want: []PackReport{
+				{Source: "a", Status: PackStatusTruncated, Tokens: 900, KeptTokens: 550},
+				{Source: "b", Status: PackStatusTruncated, Tokens: 900, KeptTokens: 277},
+				{Source: "c", Status: PackStatusDropped, Tokens: 900},
+			},
+		},
+		{
+			name: "segments past the limit are dropped",
+			documents: []Document{{
+				Source:   "a",
+				Contents: []llm.Segment{llm.Text(strings.Repeat("a", 400)), &llm.InlineData{}, llm.Text(strings.Repeat("a", 400))},
+			}},
+			budget: 500,
+			want: []PackReport{
+				{Source: "a", Status: PackStatusTruncated, Tokens: 200 + 768, KeptTokens: 100},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packed, reports := Pack(tt.documents, tt.budget)
+			if !reflect.DeepEqual(reports, tt.want) {
+				t.Errorf("Pack() reports =\n%+v\nwant\n%+v", reports, tt.want)
+			}
+
+			var sources []string
+			for _, r := range tt.want {
+				if r.Status != PackStatusDropped {
+					sources = append(sources, r.Source)
+				}
+			}
+			var got []string
+			for _, doc := range packed {
+				got = append(got, doc.Source)
+			}
+			if !reflect.DeepEqual(got, sources) {
+				t.Errorf("Pack() documents = %v, want %v", got, sources)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package citation
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// A line is a claim if it has at least minClaimWords words or, for
+	// languages written without spaces, minClaimRunes characters.
+	minClaimWords = 6
+	minClaimRunes = 40
+
+	// maxMarkerBytes bounds how much text is held back as a possible marker
+	// split across writes.
+	maxMarkerBytes = 64
+)
+
+// Citation is a reference of an answer to a source document, written as
+// "§[<document number>]".
+type Citation struct {
+	Source int  `json:"source"` // cited document number
+	Valid  bool `json:"valid"`  // the document number exists
+
+	// Start and End are the offsets of the marker in the answer, in
+	// characters (Unicode code points). A marker citing several documents
+	// yields one Citation per document, with the same offsets.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Claim is a statement of an answer without a valid citation.
+type Claim struct {
+	Start int    `json:"start"` // offsets in the answer, in characters
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// Report summarizes the citations of an answer.
+type Report struct {
+	Citations int `json:"citations"` // valid citations
+	Invalid   int `json:"invalid"`   // citations of document numbers that do not exist
+	Claims    int `json:"claims"`    // statements checked
+	Uncited   int `json:"uncited"`   // statements without a valid citation
+
+	// Flagged is set if the answer has statements without a valid citation
+	// or cites documents that do not exist.
+	Flagged bool `json:"flagged"`
+}
+
+var markerRe = regexp.MustCompile(`§\[([\d,\s]+)\]`)
+var partialMarkerRe = regexp.MustCompile(`§(\[[\d,\s]*)?$`)
+var numberRe = regexp.MustCompile(`\d+`)
+var listMarkerRe = regexp.MustCompile(`^(?:>\s*)*(?:[-*+]|\d+[.)])?\s*`)
+
+// Parser finds the citations of an answer as it is streamed and checks them
+// against the numbers of the source documents.
+//
+// Statements are checked per line of Markdown: every paragraph or list item
+// long enough to be a claim needs a valid citation. Headings, tables, code
+// blocks and lines introducing a list (ending with a colon) are not claims.
+type Parser struct {
+	sources int
+
+	pending string // held back, possibly the start of a marker
+	offset  int    // characters of the answer before pending
+
+	line      strings.Builder // text of the current line, without markers
+	lineStart int
+	lineCited bool
+	inFence   bool
+
+	report Report
+}
+
+// NewParser returns a parser for an answer citing sources documents,
+// numbered from 1.
+func NewParser(sources int) *Parser {
+	return &Parser{sources: sources}
+}
+
+// Write consumes the next part of the answer. It returns the citations and
+// the uncited claims completed by it.
+func (p *Parser) Write(text string) ([]Citation, []Claim) {
+	text = p.pending + text
+	p.pending = ""
+	if i := incompleteRune(text); i >= 0 {
+		text, p.pending = text[:i], text[i:]
+	}
+	if loc := partialMarkerRe.FindStringIndex(text); loc != nil && len(text)-loc[0] <= maxMarkerBytes {
+		text, p.pending = text[:loc[0]], text[loc[0]:]+p.pending
+	}
+	return p.consume(text)
+}
+
+// incompleteRune returns the start of a UTF-8 sequence cut off at the end of
+// text, or -1.
+func incompleteRune(text string) int {
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRuneInString(text[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return -1
+}
+
+// Close ends the answer. It returns the remaining citations and uncited
+// claims, and the report of the whole answer.
+func (p *Parser) Close() ([]Citation, []Claim, *Report) {
+	text := p.pending
+	p.pending = ""
+	citations, claims := p.consume(text)
+	if claim, ok := p.endLine(); ok {
+		claims = append(claims, claim)
+	}
+
+	report := p.report
+	report.Flagged = report.Uncited > 0 || report.Invalid > 0
+	return citations, claims, &report
+}
+
+func (p *Parser) consume(text string) ([]Citation, []Claim) {
+	var citations []Citation
+	var claims []Claim
+
+	plain := func(s string) {
+		for _, r := range s {
+			if r == '\n' {
+				if claim, ok := p.endLine(); ok {
+					claims = append(claims, claim)
+				}
+				p.offset++
+				p.lineStart = p.offset
+				continue
+			}
+			p.line.WriteRune(r)
+			p.offset++
+		}
+	}
+
+	last := 0
+	for _, m := range markerRe.FindAllStringSubmatchIndex(text, -1) {
+		plain(text[last:m[0]])
+		start := p.offset
+		p.offset += utf8.RuneCountInString(text[m[0]:m[1]])
+		for _, s := range numberRe.FindAllString(text[m[2]:m[3]], -1) {
+			n, err := strconv.Atoi(s)
+			valid := err == nil && n >= 1 && n <= p.sources
+			if valid {
+				p.report.Citations++
+				p.lineCited = true
+			} else {
+				p.report.Invalid++
+			}
+			citations = append(citations, Citation{Source: n, Valid: valid, Start: start, End: p.offset})
+		}
+		last = m[1]
+	}
+	plain(text[last:])
+
+	return citations, claims
+}
+
+// endLine checks the current line and starts a new one. It reports the line
+// if it is an uncited claim.
+func (p *Parser) endLine() (Claim, bool) {
+	text := strings.TrimSpace(p.line.String())
+	start, end, cited := p.lineStart, p.offset, p.lineCited
+	p.line.Reset()
+	p.lineCited = false
+
+	if strings.HasPrefix(text, "```") || strings.HasPrefix(text, "~~~") {
+		p.inFence = !p.inFence
+		return Claim{}, false
+	}
+	if p.inFence || !isClaim(text) {
+		return Claim{}, false
+	}
+
+	p.report.Claims++
+	if cited {
+		return Claim{}, false
+	}
+	p.report.Uncited++
+	return Claim{Start: start, End: end, Text: text}, true
+}
+
+// isClaim reports whether a line of Markdown states something that needs a
+// source.
+func isClaim(line string) bool {
+	if line == "" || strings.HasSuffix(line, ":") {
+		return false
+	}
+	switch line[0] {
+	case '#', '|':
+		return false
+	}
+	if strings.Trim(line, "-*_ ") == "" { // thematic break
+		return false
+	}
+
+	line = listMarkerRe.ReplaceAllString(line, "")
+	return len(strings.Fields(line)) >= minClaimWords || utf8.RuneCountInString(line) >= minClaimRunes
+}
@@ -0,0 +1,191 @@
+package citation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// parse feeds answer to a parser in writes of chunk bytes (all at once if
+// chunk is 0) and returns everything it reported.
+func parse(sources int, answer string, chunk int) ([]Citation, []Claim, *Report) {
+	p := NewParser(sources)
+	var citations []Citation
+	var claims []Claim
+	for len(answer) > 0 {
+		n := len(answer)
+		if chunk > 0 && chunk < n {
+			n = chunk
+		}
+		c, u := p.Write(answer[:n])
+		citations = append(citations, c...)
+		claims = append(claims, u...)
+		answer = answer[n:]
+	}
+	c, u, report := p.Close()
+	return append(citations, c...), append(claims, u...), report
+}
+
+// offset returns the offset of substr in s, in characters.
+func offset(s, substr string) int {
+	return utf8.RuneCountInString(s[:strings.Index(s, substr)])
+}
+
+const answer = "# Go generics\n" +
+	"Go added generics in version 1.18 of the language §[1].\n" +
+	"Generics let functions take type parameters, as the proposal describes §[2, 3].\n" +
+	"- This list item states an uncited fact about the language design.\n" +
+	"Short line.\n" +
+	"Here is an example:\n" +
+	"```go\n" +
+	"func Map[T any](s []T) []T { return s } // code never needs a source §[7]\n" +
+	"```\n" +
+	"| Version | Feature of the release that is listed here |\n" +
+	"제네릭은 Go 1.18 버전에서 추가된 기능으로 타입 매개변수를 사용하여 함수를 작성할 수 있습니다§[9]."
+
+func TestParser(t *testing.T) {
+	marker := func(m string, source int, valid bool) Citation {
+		start := offset(answer, m)
+		return Citation{Source: source, Valid: valid, Start: start, End: start + utf8.RuneCountInString(m)}
+	}
+	listItem := "- This list item states an uncited fact about the language design."
+	korean := "제네릭은 Go 1.18 버전에서 추가된 기능으로 타입 매개변수를 사용하여 함수를 작성할 수 있습니다"
+
+	wantCitations := []Citation{
+		marker("§[1]", 1, true),
+		marker("§[2, 3]", 2, true),
+		marker("§[2, 3]", 3, true),
+		marker("§[7]", 7, false),
+		marker("§[9]", 9, false),
+	}
+	wantClaims := []Claim{
+		{Start: offset(answer, listItem), End: offset(answer, listItem) + utf8.RuneCountInString(listItem), Text: listItem},
+		{Start: offset(answer, korean), End: utf8.RuneCountInString(answer), Text: korean + "."},
+	}
+	wantReport := &Report{Citations: 3, Invalid: 2, Claims: 4, Uncited: 2, Flagged: true}
+
+	// Every way of splitting the answer into writes gives the same result,
+	// including markers and multi-byte characters split across writes.
+	for _, chunk := range []int{0, 1, 2, 3, 5, 64} {
+		citations, claims, report := parse(3, answer, chunk)
+		if !reflect.DeepEqual(citations, wantCitations) {
+			t.Errorf("chunk %d: citations = %+v, want %+v", chunk, citations, wantCitations)
+		}
+		if !reflect.DeepEqual(claims, wantClaims) {
+			t.Errorf("chunk %d: claims = %+v, want %+v", chunk, claims, wantClaims)
+		}
+		if !reflect.DeepEqual(report, wantReport) {
+			t.Errorf("chunk %d: report = %+v, want %+v", chunk, report, wantReport)
+		}
+	}
+}
+
+func TestParserWrites(t *testing.T) {
+	tests := []struct {
+		name          string
+		writes        []string
+		wantCitations []Citation
+	}{
+		{
+			name:          "marker split after the section sign",
+			writes:        []string{"fact §", "[1]"},
+			wantCitations: []Citation{{Source: 1, Valid: true, Start: 5, End: 9}},
+		},
+		{
+			name:          "marker split inside the numbers",
+			writes:        []string{"fact §[1", "2, ", "1]"},
+			wantCitations: []Citation{{Source: 12, Start: 5, End: 13}, {Source: 1, Valid: true, Start: 5, End: 13}},
+		},
+		{
+			name:          "section sign split between its bytes",
+			writes:        []string{"사실 \xc2", "\xa7[2]"},
+			wantCitations: []Citation{{Source: 2, Valid: true, Start: 3, End: 7}},
+		},
+		{
+			name:   "unfinished marker is text",
+			writes: []string{"fact §[1", " and more"},
+		},
+		{
+			name:   "section sign at the end is text",
+			writes: []string{"see §"},
+		},
+		{
+			name:   "not a marker",
+			writes: []string{"§ 2 of the law, §[a]"},
+		},
+		{
+			name:          "zero is not a document",
+			writes:        []string{"§[0]"},
+			wantCitations: []Citation{{Source: 0, Start: 0, End: 4}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(2)
+			var citations []Citation
+			for _, w := range tt.writes {
+				c, _ := p.Write(w)
+				citations = append(citations, c...)
+			}
+			c, _, _ := p.Close()
+			citations = append(citations, c...)
+			if !reflect.DeepEqual(citations, tt.wantCitations) {
+				t.Errorf("citations = %+v, want %+v", citations, tt.wantCitations)
+			}
+		})
+	}
+}
+
+func TestParserFences(t *testing.T) {
+	code := "This sentence outside of the fence has no citation at all.\n" +
+		"~~~\n" +
+		"this line inside the tilde fence is not a claim at all\n" +
+		"~~~\n" +
+		"  ```\n" +
+		"neither is this line inside the indented backtick fence\n" +
+		"  ```\n" +
+		"This sentence after the fences has a citation to a source §[1].\n"
+	_, claims, report := parse(1, code, 0)
+	if len(claims) != 1 || !strings.HasPrefix(claims[0].Text, "This sentence outside") {
+		t.Errorf("claims = %+v, want only the sentence outside the fences", claims)
+	}
+	if want := (&Report{Citations: 1, Claims: 2, Uncited: 1, Flagged: true}); !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	// An answer with every claim cited is not flagged.
+	_, claims, report = parse(1, "Go added generics in version 1.18 of the language §[1].", 0)
+	if len(claims) != 0 || report.Flagged {
+		t.Errorf("claims = %+v, report = %+v, want a cited answer", claims, report)
+	}
+}
+
+func TestIsClaim(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"", false},
+		{"Go added generics in version 1.18.", true},
+		{"Go added generics.", false},
+		{"## Go added generics in version 1.18 of the language", false},
+		{"| Go | added | generics | in | version | 1.18 |", false},
+		{"The release added the following features:", false},
+		{"---", false},
+		{"* * *", false},
+		{"- Go added generics in version 1.18.", true},
+		{"12. Go added generics in 1.18.", false},
+		{"> - Go added generics in version 1.18.", true},
+		{"Go 1.18에서 제네릭이 추가되었으며 타입 매개변수로 함수를 일반화할 수 있다.", true},
+		{"東京は日本の首都であり、人口が最も多い都市として知られていて、経済の中心でもある。", true},
+		{"東京は日本の首都です。", false},
+	}
+
+	for _, tt := range tests {
+		if got := isClaim(tt.line); got != tt.want {
+			t.Errorf("isClaim(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package crawl
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// The most frequent characters of every language. Decoding a page with the
+// wrong legacy encoding usually succeeds but produces rare characters, so
+// the number of common characters tells the right encoding apart.
+const (
+	commonHangul      = "이다는의에을하고가를한지서로기사리자수도어아대시정보스일적인나있그우해전주게면라상세"
+	commonSimplified  = "的一是不了在人有我他这个们中来上大为和国地到以说时要就出会可也你对生能而子那得于着下自之年过发后作里用道行所然家种事成方多经么去法学如都同现当没动面起看定天分还进好小部其些主样理心她本前开但因只从想实"
+	commonTraditional = "的一是不了在人有我他這個們中來上大為和國地到以說時要就出會可也你對生能而子那得於著下自之年過發後作裡用道行所然家種事成方多經麼去法學如都同現當沒動面起看定天分還進好小部其些主樣理心她本前開但因只從想實"
+)
+
+// metaPrescanBytes is how much of a document is searched for a <meta>
+// charset declaration, as in the prescan of the HTML standard.
+const metaPrescanBytes = 1024
+
+func isKana(r rune) bool {
+	// Hiragana and full-width Katakana only: half-width Katakana are what
+	// single high bytes decode to in Shift_JIS.
+	return r >= 0x3041 && r <= 0x30FF
+}
+
+func inString(set string) func(rune) bool {
+	return func(r rune) bool {
+		return strings.ContainsRune(set, r)
+	}
+}
+
+// sniffCandidates are the legacy encodings tried when a page declares no
+// charset and is not valid UTF-8.
+var sniffCandidates = []struct {
+	name     string
+	encoding encoding.Encoding
+	common   func(rune) bool
+}{
+	{"euc-kr", korean.EUCKR, inString(commonHangul)},
+	{"shift_jis", japanese.ShiftJIS, isKana},
+	{"euc-jp", japanese.EUCJP, isKana},
+	{"gbk", simplifiedchinese.GBK, inString(commonSimplified)},
+	{"big5", traditionalchinese.Big5, inString(commonTraditional)},
+}
+
+// DecodeHTML converts an HTML document to UTF-8 and returns it together with
+// the name of the detected charset.
+//
+// The charset is taken from the Content-Type header, a byte order mark or a
+// <meta> tag. When none is present, valid UTF-8 is kept as is and other
+// content is sniffed against common CJK encodings (EUC-KR, Shift_JIS,
+// EUC-JP, GBK, Big5), falling back to windows-1252.
+func DecodeHTML(body []byte, contentType string) (string, string) {
+	e, name, certain := charset.DetermineEncoding(body, contentType)
+	// DetermineEncoding is only certain of the header and the byte order
+	// mark; a charset declared in a <meta> tag is trusted as well, while
+	// its guesses (UTF-8 or windows-1252) are not.
+	if !certain && !declaresCharset(body) {
+		if utf8.Valid(body) {
+			return string(body), "utf-8"
+		}
+		e, name = sniffEncoding(body)
+	}
+
+	if name == "utf-8" {
+		return string(bytes.ToValidUTF8(body, []byte("�"))), name
+	}
+
+	decoded, err := e.NewDecoder().Bytes(body)
+	if err != nil {
+		return string(bytes.ToValidUTF8(body, []byte("�"))), "utf-8"
+	}
+	return string(decoded), name
+}
+
+// declaresCharset reports whether a <meta> tag at the start of an HTML
+// document declares a known charset, either as <meta charset> or in the
+// content attribute of <meta http-equiv="Content-Type">.
+func declaresCharset(body []byte) bool {
+	z := html.NewTokenizer(bytes.NewReader(body[:min(len(body), metaPrescanBytes)]))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return false
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			if string(tag) != "meta" {
+				continue
+			}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				var label string
+				switch string(key) {
+				case "charset":
+					label = string(val)
+				case "content":
+					_, after, ok := strings.Cut(strings.ToLower(string(val)), "charset=")
+					if !ok {
+						continue
+					}
+					label, _, _ = strings.Cut(strings.Trim(after, ` "'`), ";")
+				default:
+					continue
+				}
+				if e, _ := charset.Lookup(label); e != nil {
+					return true
+				}
+			}
+		}
+	}
+}
+
+// sniffEncoding decodes body with every candidate encoding and picks the one
+// that yields the fewest invalid characters and the most common characters
+// of the language the encoding is used for.
+func sniffEncoding(body []byte) (encoding.Encoding, string) {
+	var best encoding.Encoding = charmap.Windows1252
+	bestName := "windows-1252"
+	bestScore := 0
+
+	for _, candidate := range sniffCandidates {
+		decoded, err := candidate.encoding.NewDecoder().Bytes(body)
+		if err != nil {
+			continue
+		}
+
+		var score int
+		for _, r := range string(decoded) {
+			switch {
+			case r == utf8.RuneError:
+				score -= 10
+			case candidate.common(r):
+				score++
+			}
+		}
+
+		if score > bestScore {
+			best, bestName, bestScore = candidate.encoding, candidate.name, score
+		}
+	}
+
+	return best, bestName
+}
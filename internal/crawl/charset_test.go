@@ -0,0 +1,159 @@
+package crawl
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	textRussian  = "Москва является столицей России и крупнейшим городом страны."
+	textKorean   = "서울은 대한민국의 수도이며 가장 큰 도시이다. 한강이 도시를 가로질러 흐른다."
+	textJapanese = "東京は日本の首都であり、世界で最も人口の多い都市圏のひとつです。"
+	textChinese  = "北京是中国的首都，也是一个有着三千多年历史的城市。"
+	textFrench   = "Le café de la gare est fermé pour l'été, à partir de la mi-juillet."
+)
+
+func encode(t *testing.T, e encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := e.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func page(head, text string) string {
+	return "<!DOCTYPE html><html><head>" + head + "<title>t</title></head><body><p>" + text + "</p></body></html>"
+}
+
+func TestDecodeHTML(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoding    encoding.Encoding
+		head        string
+		contentType string
+		text        string
+		want        string // charset name
+	}{
+		{
+			name:        "header charset",
+			encoding:    charmap.Windows1251,
+			contentType: "text/html; charset=windows-1251",
+			text:        textRussian,
+			want:        "windows-1251",
+		},
+		{
+			name:        "header overrides meta",
+			encoding:    charmap.Windows1251,
+			head:        `<meta charset="utf-8">`,
+			contentType: "text/html; charset=cp1251",
+			text:        textRussian,
+			want:        "windows-1251",
+		},
+		{
+			name:     "meta charset windows-1251",
+			encoding: charmap.Windows1251,
+			head:     `<meta charset="windows-1251">`,
+			text:     textRussian,
+			want:     "windows-1251",
+		},
+		{
+			name:     "meta http-equiv EUC-KR",
+			encoding: korean.EUCKR,
+			head:     `<meta http-equiv="Content-Type" content="text/html; charset=EUC-KR">`,
+			text:     textKorean,
+			want:     "euc-kr",
+		},
+		{
+			name:     "meta charset Shift_JIS",
+			encoding: japanese.ShiftJIS,
+			head:     `<meta charset="Shift_JIS">`,
+			text:     textJapanese,
+			want:     "shift_jis",
+		},
+		{
+			name:     "meta charset GBK",
+			encoding: simplifiedchinese.GBK,
+			head:     `<meta http-equiv="content-type" content="text/html;charset=gbk" />`,
+			text:     textChinese,
+			want:     "gbk",
+		},
+		{
+			name:     "meta charset windows-1252",
+			encoding: charmap.Windows1252,
+			head:     `<meta charset="windows-1252">`,
+			text:     textFrench,
+			want:     "windows-1252",
+		},
+		{
+			name:     "unknown meta charset is sniffed",
+			encoding: korean.EUCKR,
+			head:     `<meta charset="x-unknown">`,
+			text:     textKorean,
+			want:     "euc-kr",
+		},
+		{
+			name:     "UTF-8 BOM",
+			encoding: unicode.UTF8BOM,
+			head:     `<meta charset="windows-1251">`,
+			text:     textRussian,
+			want:     "utf-8",
+		},
+		{
+			name:     "UTF-16 BOM",
+			encoding: unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+			text:     textKorean,
+			want:     "utf-16le",
+		},
+		{
+			name:     "undeclared UTF-8",
+			encoding: encoding.Nop,
+			text:     textJapanese,
+			want:     "utf-8",
+		},
+		{
+			name:     "undeclared EUC-KR",
+			encoding: korean.EUCKR,
+			text:     textKorean,
+			want:     "euc-kr",
+		},
+		{
+			name:     "undeclared Shift_JIS",
+			encoding: japanese.ShiftJIS,
+			text:     textJapanese,
+			want:     "shift_jis",
+		},
+		{
+			name:     "undeclared GBK",
+			encoding: simplifiedchinese.GBK,
+			text:     textChinese,
+			want:     "gbk",
+		},
+		{
+			name:     "undeclared Latin",
+			encoding: charmap.Windows1252,
+			text:     textFrench,
+			want:     "windows-1252",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := encode(t, tt.encoding, page(tt.head, tt.text))
+			decoded, name := DecodeHTML(body, tt.contentType)
+			if name != tt.want {
+				t.Errorf("DecodeHTML() charset = %q, want %q", name, tt.want)
+			}
+			if !strings.Contains(decoded, tt.text) {
+				t.Errorf("DecodeHTML() = %q, want it to contain %q", decoded, tt.text)
+			}
+		})
+	}
+}
@@ -109,7 +109,8 @@ func ScrapeHTTP(client *http.Client, url string) (string, error) {
 		return "", err
 	}
 
-	return string(body), nil
+	html, _ := DecodeHTML(body, resp.Header.Get("Content-Type"))
+	return html, nil
 }
 
 func ScrapeCDPImages(url string) ([]llm.InlineData, error) {
@@ -0,0 +1,106 @@
+package followup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/coord/llmtools"
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/tokens"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// answerTokens is the size earlier answers are cut to in the decision
+// prompt.
+const answerTokens = 1000
+
+// Decision tells how to answer a follow-up question.
+type Decision struct {
+	// Search is set when the conversation and the pages already read are
+	// not enough to answer, and new web searches are needed.
+	Search bool   `yaml:"search" json:"search"`
+	Reason string `yaml:"reason" json:"reason"`
+
+	// Query is the follow-up rewritten to be understood without the
+	// conversation, used to plan the searches.
+	Query string `yaml:"query" json:"query"`
+}
+
+const prompt = `You are continuing a conversation of a web search assistant. Current time is {{CURRENT_TIME}}.
+
+The conversation so far:
+<conversation>
+{{CONVERSATION}}
+</conversation>
+
+The webpages that were read to answer it:
+<sources>
+{{SOURCES}}
+</sources>
+
+The user now asks:
+<follow_up>
+{{FOLLOW_UP}}
+</follow_up>
+
+1. Decide whether answering the follow-up needs new web searches. No search is needed if the conversation and the webpages already read cover it, e.g. when the user asks to explain, summarize, compare or reformat what was already said. A search is needed if the follow-up asks for information that was not covered, or for more recent information.
+
+2. Rewrite the follow-up so that it can be understood without the conversation: resolve references such as "it" or "the second one" and add the subject of the conversation. Keep the language of the follow-up.
+
+3. Provide your output in YAML format, structured as follows:
+
+` + "```yaml\n" + `
+search: (true or false)
+reason: "(short explanation of the decision)"
+query: "(the rewritten follow-up)"
+` + "```"
+
+// Decide asks the model whether the follow-up question query needs new
+// searches, given the earlier turns of the conversation in history and the
+// URLs of the pages already read.
+func Decide(ctx context.Context, m llm.Model, history []chat.Turn, query string, sources []string) (*Decision, error) {
+	var conversation strings.Builder
+	for _, turn := range history {
+		fmt.Fprintf(&conversation, "<user>\n%s\n</user>\n<assistant>\n%s\n</assistant>\n", turn.Query, tokens.Truncate(turn.Answer, answerTokens))
+	}
+	sourceList := "(none)"
+	if len(sources) > 0 {
+		sourceList = "- " + strings.Join(sources, "\n- ")
+	}
+
+	p := strings.NewReplacer(
+		"{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z),
+		"{{CONVERSATION}}", strings.TrimSpace(conversation.String()),
+		"{{SOURCES}}", sourceList,
+		"{{FOLLOW_UP}}", query,
+	).Replace(prompt)
+
+	stream := m.GenerateStream(ctx, &llm.ChatContext{}, llm.TextContent(llm.RoleUser, p))
+	err := stream.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(llmtools.TextFromContents(stream.Content))
+	for _, fence := range []string{"```yaml\n", "```yml\n", "```\n"} {
+		if _, after, ok := strings.Cut(text, fence); ok {
+			text, _, _ = strings.Cut(after, "```")
+			break
+		}
+	}
+
+	var decision Decision
+	err = yaml.Unmarshal([]byte(text), &decision)
+	if err != nil {
+		return nil, fmt.Errorf("invalid follow-up decision: %w", err)
+	}
+	decision.Query = strings.TrimSpace(decision.Query)
+	if decision.Search && decision.Query == "" {
+		return nil, errors.New("invalid follow-up decision: empty query")
+	}
+	return &decision, nil
+}
@@ -0,0 +1,105 @@
+package followup
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/chat"
+)
+
+// replyModel answers every request with the same text, or fails with err,
+// and records the prompt it was given.
+type replyModel struct {
+	text   string
+	err    error
+	prompt string
+}
+
+func (m *replyModel) GenerateStream(ctx context.Context, chat *llm.ChatContext, input *llm.Content) *llm.StreamContent {
+	if text, ok := input.Parts[0].(llm.Text); ok {
+		m.prompt = string(text)
+	}
+	stream := make(chan llm.Segment)
+	close(stream)
+	if m.err != nil {
+		return &llm.StreamContent{Err: m.err, Stream: stream}
+	}
+	return &llm.StreamContent{Content: llm.TextContent(llm.RoleModel, m.text), Stream: stream}
+}
+
+func (m *replyModel) Close() error { return nil }
+
+func (m *replyModel) Name() string { return "reply" }
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    *Decision
+		wantErr bool
+	}{
+		{
+			name:  "no search",
+			reply: "```yaml\nsearch: false\nreason: already answered\nquery: ' Explain Go generics simply '\n```",
+			want:  &Decision{Reason: "already answered", Query: "Explain Go generics simply"},
+		},
+		{
+			name:  "search",
+			reply: "Sure.\n```\nsearch: true\nreason: needs benchmarks\nquery: Go generics performance\n```",
+			want:  &Decision{Search: true, Reason: "needs benchmarks", Query: "Go generics performance"},
+		},
+		{
+			name:  "unfenced",
+			reply: "search: false\nquery: q\n",
+			want:  &Decision{Query: "q"},
+		},
+		{name: "search without a query", reply: "search: true\nquery: ' '\n", wantErr: true},
+		{name: "invalid YAML", reply: "search: [", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decide(context.Background(), &replyModel{text: tt.reply}, nil, "follow-up", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decide() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decide() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecidePrompt(t *testing.T) {
+	m := &replyModel{text: "search: false\nquery: q\n"}
+	history := []chat.Turn{
+		{Query: "what is go", Answer: "Go is a language."},
+		{Query: "who made it", Answer: strings.Repeat("long answer ", 2000)},
+	}
+	if _, err := Decide(context.Background(), m, history, "and rust?", []string{"https://go.dev/"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<user>\nwhat is go\n</user>", "Go is a language.", "<user>\nwho made it\n</user>", "- https://go.dev/", "<follow_up>\nand rust?\n</follow_up>"} {
+		if !strings.Contains(m.prompt, want) {
+			t.Errorf("Decide() prompt does not contain %q", want)
+		}
+	}
+	if strings.Count(m.prompt, "long answer") >= 2000 {
+		t.Error("Decide() prompt has the whole of a long answer")
+	}
+
+	m = &replyModel{text: "search: false\nquery: q\n"}
+	Decide(context.Background(), m, nil, "q", nil)
+	if !strings.Contains(m.prompt, "<sources>\n(none)\n</sources>") {
+		t.Errorf("Decide() prompt without sources = %q, want (none)", m.prompt)
+	}
+
+	errModel := errors.New("unavailable")
+	if _, err := Decide(context.Background(), &replyModel{err: errModel}, nil, "q", nil); !errors.Is(err, errModel) {
+		t.Errorf("Decide() error = %v, want %v", err, errModel)
+	}
+}
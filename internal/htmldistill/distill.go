@@ -20,6 +20,25 @@ var usefulattrs = map[string]bool{
 	"value":       true,
 	"content":     true,
 	"property":    true,
+
+	"data-language": true,
+	"start":         true,
+	"colspan":       true,
+	"rowspan":       true,
+}
+
+// preformattedTags are protected regions whose whitespace is significant.
+var preformattedTags = map[string]bool{
+	"pre":      true,
+	"code":     true,
+	"textarea": true,
+}
+
+// tableCellTags are kept even when empty, as removing them would shift the
+// columns of the table.
+var tableCellTags = map[string]bool{
+	"td": true,
+	"th": true,
 }
 
 func distillPipeline(n *html.Node) {
@@ -27,6 +46,13 @@ func distillPipeline(n *html.Node) {
 		return
 	}
 
+	if n.Type == html.ElementNode && (n.Data == "pre" || n.Data == "code") {
+		// Keep the language hint of code blocks before the class attribute is stripped.
+		if lang := codeLanguage(n); lang != "" && getAttr(n, "data-language") == "" {
+			n.Attr = append(n.Attr, html.Attribute{Key: "data-language", Val: lang})
+		}
+	}
+
 	unusefulE := false
 	for i := range n.Attr {
 		if !usefulattrs[n.Attr[i].Key] {
@@ -67,6 +93,11 @@ func trimWhitespaceAndRemoveEmptyTags(n *html.Node) {
 		return
 	}
 
+	// Keep indentation and line breaks of code blocks untouched
+	if n.Type == html.ElementNode && preformattedTags[n.Data] {
+		return
+	}
+
 	// Trim whitespace in text nodes
 	if n.Type == html.TextNode {
 		n.Data = strings.TrimSpace(n.Data)
@@ -78,8 +109,8 @@ func trimWhitespaceAndRemoveEmptyTags(n *html.Node) {
 		next = c.NextSibling
 		trimWhitespaceAndRemoveEmptyTags(c)
 
-		// Remove empty tags, excluding self-closing tags
-		if c.Type == html.ElementNode && c.FirstChild == nil && !isSelfClosingTag(c.Data) {
+		// Remove empty tags, excluding self-closing tags, table cells and code blocks
+		if c.Type == html.ElementNode && c.FirstChild == nil && !isSelfClosingTag(c.Data) && !tableCellTags[c.Data] && !preformattedTags[c.Data] {
 			n.RemoveChild(c)
 		}
 	}
@@ -108,6 +139,11 @@ func removeSelfClosingTagsWithoutAttr(n *html.Node) {
 		return
 	}
 
+	// <br> tags are line breaks inside code blocks
+	if n.Type == html.ElementNode && preformattedTags[n.Data] {
+		return
+	}
+
 	var next *html.Node
 	for c := n.FirstChild; c != nil; c = next {
 		next = c.NextSibling
@@ -119,9 +155,10 @@ func removeSelfClosingTagsWithoutAttr(n *html.Node) {
 	}
 }
 
-// Clean takes an HTML string, parses it into an HTML document, runs the distillPipeline
-// function on the document to remove unnecessary elements and attributes, and then
-// renders the cleaned HTML back to a string.
+// Clean takes an HTML string, parses it into an HTML document, narrows the body down
+// to the main content when it can be identified with enough confidence, runs the
+// distillPipeline function on the document to remove unnecessary elements and
+// attributes, and then renders the cleaned HTML back to a string.
 //
 // If there is an error parsing or rendering the HTML, an error is returned.
 func Clean(s string) (string, error) {
@@ -129,12 +166,19 @@ func Clean(s string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return CleanNode(htmlnode)
+}
+
+// CleanNode is Clean for a parsed document. The document is modified in
+// place, so it must not be used for other extractions afterwards.
+func CleanNode(htmlnode *html.Node) (string, error) {
+	extractMainContent(htmlnode)
 	distillPipeline(htmlnode)
 	trimWhitespaceAndRemoveEmptyTags(htmlnode)
 	removeSelfClosingTagsWithoutAttr(htmlnode)
 
 	var sb strings.Builder
-	err = html.Render(&sb, htmlnode)
+	err := html.Render(&sb, htmlnode)
 	if err != nil {
 		return "", err
 	}
@@ -142,8 +186,18 @@ func Clean(s string) (string, error) {
 	return sb.String(), nil
 }
 
+// Parse parses an HTML document for the *Node variants of the extraction
+// functions, so that a page can be parsed once for all of them. The
+// functions that only read the document (ExtractMetadataNode,
+// ExtractInventoryNode) must run before the ones that distill it (CleanNode,
+// ToMarkdownNode).
+func Parse(s string) (*html.Node, error) {
+	return html.Parse(strings.NewReader(s))
+}
+
 // ExtractText takes an HTML string and returns the extracted text content.
-// It removes all HTML tags and returns only the text nodes.
+// It removes all HTML tags and returns only the text nodes. The contents of
+// <pre> blocks are returned verbatim.
 func ExtractText(s string) (string, error) {
 	doc, err := html.Parse(strings.NewReader(s))
 	if err != nil {
@@ -160,6 +214,11 @@ func ExtractText(s string) (string, error) {
 			buf.WriteString(strings.TrimSpace(n.Data))
 			buf.WriteString("\n")
 		}
+		if n.Type == html.ElementNode && n.Data == "pre" {
+			buf.WriteString(strings.Trim(textContent(n), "\n"))
+			buf.WriteString("\n")
+			return
+		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			extractTextRecursive(c)
 		}
@@ -0,0 +1,67 @@
+package htmldistill
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestGolden runs Clean and ToMarkdown over the saved pages in testdata and
+// compares the results with the golden files next to them. Run
+//
+//	go test ./internal/htmldistill -run TestGolden -update
+//
+// to regenerate the golden files after an intended change of the output.
+func TestGolden(t *testing.T) {
+	pages, err := filepath.Glob(filepath.Join("testdata", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) == 0 {
+		t.Fatal("no pages in testdata")
+	}
+
+	outputs := []struct {
+		ext     string
+		distill func(string) (string, error)
+	}{
+		{".clean.golden", Clean},
+		{".md.golden", ToMarkdown},
+	}
+
+	for _, page := range pages {
+		raw, err := os.ReadFile(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, output := range outputs {
+			golden := strings.TrimSuffix(page, ".html") + output.ext
+			t.Run(filepath.Base(golden), func(t *testing.T) {
+				got, err := output.distill(string(raw))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("output differs from %s:\n%s", golden, got)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package htmldistill
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type Link struct {
+	URL        string `json:"url"`
+	Text       string `json:"text,omitempty"`
+	Navigation bool   `json:"navigation,omitempty"` // inside <nav>, <header>, <footer> or <aside>
+}
+
+type Image struct {
+	URL    string `json:"url"`
+	Alt    string `json:"alt,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// Inventory lists the outbound links and the images of a page.
+type Inventory struct {
+	Links  []Link  `json:"links,omitempty"`
+	Images []Image `json:"images,omitempty"`
+
+	// LinkDensity is the share of the visible text of the page that is
+	// anchor text.
+	LinkDensity float64 `json:"link_density"`
+}
+
+var navigationTags = map[string]bool{
+	"nav":    true,
+	"header": true,
+	"footer": true,
+	"aside":  true,
+}
+
+const (
+	// hubLinkDensity is the link density above which a page is considered an
+	// index of other pages rather than content.
+	hubLinkDensity = 0.4
+	// hubMinLinks is the minimum number of content links of a hub page.
+	hubMinLinks = 10
+)
+
+// IsHub reports whether the page looks like a hub or index page, i.e. a page
+// mostly made of links to other pages.
+func (inv *Inventory) IsHub() bool {
+	var content int
+	for _, l := range inv.Links {
+		if !l.Navigation && l.Text != "" {
+			content++
+		}
+	}
+	return inv.LinkDensity >= hubLinkDensity && content >= hubMinLinks
+}
+
+// ExtractInventory takes an HTML string and the URL it was fetched from and
+// returns the outbound links (with anchor text) and the images (with alt text
+// and dimensions) of the page. URLs are resolved against pageURL, links to
+// the page itself and non-HTTP links are skipped, and duplicates are removed.
+func ExtractInventory(s string, pageURL string) (*Inventory, error) {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+	return ExtractInventoryNode(doc, pageURL), nil
+}
+
+// ExtractInventoryNode is ExtractInventory for a parsed document, which it
+// leaves untouched.
+func ExtractInventoryNode(doc *html.Node, pageURL string) *Inventory {
+	base, _ := url.Parse(pageURL)
+	var inv Inventory
+	seenLinks := make(map[string]int)
+	seenImages := make(map[string]bool)
+
+	body := findElement(doc, "body")
+	if body == nil {
+		body = doc
+	}
+	stats := make(map[*html.Node]*nodeStats)
+	bodyStats := collectStats(body, stats)
+	inv.LinkDensity = bodyStats.linkDensity()
+
+	var walk func(n *html.Node, navigation bool)
+	walk = func(n *html.Node, navigation bool) {
+		if n.Type == html.ElementNode {
+			if ignoredTextTags[n.Data] {
+				return
+			}
+			if navigationTags[n.Data] {
+				navigation = true
+			}
+
+			switch n.Data {
+			case "a":
+				u := resolveLink(base, getAttr(n, "href"))
+				if u == "" {
+					break
+				}
+				text := collapseWhitespace(textContent(n))
+				if text == "" {
+					text = collapseWhitespace(getAttr(n, "title"))
+				}
+				if text == "" {
+					text = collapseWhitespace(getAttr(n, "aria-label"))
+				}
+				if i, ok := seenLinks[u]; ok {
+					if inv.Links[i].Text == "" {
+						inv.Links[i].Text = text
+					}
+					inv.Links[i].Navigation = inv.Links[i].Navigation && navigation
+					break
+				}
+				seenLinks[u] = len(inv.Links)
+				inv.Links = append(inv.Links, Link{URL: u, Text: text, Navigation: navigation})
+			case "img":
+				src := getAttr(n, "src")
+				if strings.HasPrefix(src, "data:") {
+					break
+				}
+				u := resolveLink(base, src)
+				if u == "" || seenImages[u] {
+					break
+				}
+				seenImages[u] = true
+				width, _ := strconv.Atoi(strings.TrimSuffix(getAttr(n, "width"), "px"))
+				height, _ := strconv.Atoi(strings.TrimSuffix(getAttr(n, "height"), "px"))
+				inv.Images = append(inv.Images, Image{
+					URL:    u,
+					Alt:    collapseWhitespace(getAttr(n, "alt")),
+					Width:  width,
+					Height: height,
+				})
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, navigation)
+		}
+	}
+	walk(body, false)
+
+	return &inv
+}
+
+// resolveLink resolves ref against base and returns an absolute http(s) URL
+// without fragment, or an empty string if ref points to the page itself or
+// is not a web link.
+func resolveLink(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if base != nil && u.Host == base.Host && u.Path == base.Path && u.RawQuery == base.RawQuery {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,135 @@
+package htmldistill
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractInventory(t *testing.T) {
+	page := `<html><head><base href="https://ignored.example/"></head><body>
+<nav><a href="/">Home</a><a href="/docs/">Docs</a></nav>
+<article>
+<p>Read the <a href="guide/intro#setup">introduction</a> and the
+<a href="https://other.example/post?id=1">related post</a>.</p>
+<p><a href="/docs/">Documentation</a> <a href="#top">Top</a>
+<a href="mailto:me@example.com">Mail</a> <a href="javascript:void(0)">Click</a>
+<a href="?page=2#x">Next page</a> <a href="page.html">This page</a>
+<a href="/img" title="  Image   gallery "></a> <a href="/empty"></a></p>
+<img src="/a.png" alt=" A  chart " width="640px" height="480">
+<img src="data:image/png;base64,AAAA" alt="inline">
+<img src="/a.png" alt="duplicate">
+</article>
+<script>var a = '<a href="/script">x</a>';</script>
+</body></html>`
+
+	inv, err := ExtractInventory(page, "https://example.com/guide/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantLinks := []Link{
+		{URL: "https://example.com/", Text: "Home", Navigation: true},
+		// Also linked from the article, so not only navigation; the first
+		// anchor text is kept.
+		{URL: "https://example.com/docs/", Text: "Docs"},
+		{URL: "https://example.com/guide/guide/intro", Text: "introduction"},
+		{URL: "https://other.example/post?id=1", Text: "related post"},
+		{URL: "https://example.com/guide/page.html?page=2", Text: "Next page"},
+		{URL: "https://example.com/img", Text: "Image gallery"},
+		{URL: "https://example.com/empty"},
+	}
+	if !reflect.DeepEqual(inv.Links, wantLinks) {
+		t.Errorf("Links =\n%+v\nwant\n%+v", inv.Links, wantLinks)
+	}
+
+	wantImages := []Image{{URL: "https://example.com/a.png", Alt: "A chart", Width: 640, Height: 480}}
+	if !reflect.DeepEqual(inv.Images, wantImages) {
+		t.Errorf("Images = %+v, want %+v", inv.Images, wantImages)
+	}
+}
+
+func TestIsHub(t *testing.T) {
+	links := func(n int, navigation bool) string {
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			tag := "li"
+			if navigation {
+				tag = "nav"
+			}
+			fmt.Fprintf(&sb, "<%s><a href=\"/post/%d\">Post number %d</a></%s>", tag, i, i, tag)
+		}
+		return sb.String()
+	}
+
+	tests := []struct {
+		name string
+		html string
+		want bool
+	}{
+		{name: "index of posts", html: "<h1>Blog</h1><ul>" + links(12, false) + "</ul>", want: true},
+		{name: "article", html: strings.Repeat("<p>"+longParagraph+"</p>", 3) + "<ul>" + links(12, false) + "</ul>"},
+		{name: "few links", html: "<ul>" + links(5, false) + "</ul>"},
+		{name: "navigation only", html: links(12, true)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := ExtractInventory("<html><body>"+tt.html+"</body></html>", "https://example.com/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := inv.IsHub(); got != tt.want {
+				t.Errorf("IsHub() = %v (link density %.2f), want %v", got, inv.LinkDensity, tt.want)
+			}
+		})
+	}
+}
+
+// TestNodeVariants checks that extracting everything from a single parse of
+// a page gives the same results as the string functions.
+func TestNodeVariants(t *testing.T) {
+	pages, err := filepath.Glob(filepath.Join("testdata", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const pageURL = "https://example.com/page"
+	for _, page := range pages {
+		raw, err := os.ReadFile(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := string(raw)
+
+		t.Run(filepath.Base(page), func(t *testing.T) {
+			wantMetadata, _ := ExtractMetadata(s, pageURL)
+			wantInventory, _ := ExtractInventory(s, pageURL)
+			wantClean, _ := Clean(s)
+			wantMarkdown, _ := ToMarkdown(s)
+
+			doc, err := Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ExtractMetadataNode(doc, pageURL); !reflect.DeepEqual(got, wantMetadata) {
+				t.Errorf("ExtractMetadataNode() = %+v, want %+v", got, wantMetadata)
+			}
+			if got := ExtractInventoryNode(doc, pageURL); !reflect.DeepEqual(got, wantInventory) {
+				t.Errorf("ExtractInventoryNode() differs from ExtractInventory()")
+			}
+			if got, _ := CleanNode(doc); got != wantClean {
+				t.Errorf("CleanNode() after the other extractions differs from Clean()")
+			}
+
+			doc, _ = Parse(s)
+			ExtractMetadataNode(doc, pageURL)
+			if got := ToMarkdownNode(doc); got != wantMarkdown {
+				t.Errorf("ToMarkdownNode() after ExtractMetadataNode() differs from ToMarkdown()")
+			}
+		})
+	}
+}
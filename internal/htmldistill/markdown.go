@@ -0,0 +1,497 @@
+package htmldistill
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var blockTags = map[string]bool{
+	"address":    true,
+	"article":    true,
+	"aside":      true,
+	"blockquote": true,
+	"body":       true,
+	"dd":         true,
+	"details":    true,
+	"dialog":     true,
+	"div":        true,
+	"dl":         true,
+	"dt":         true,
+	"fieldset":   true,
+	"figcaption": true,
+	"figure":     true,
+	"footer":     true,
+	"form":       true,
+	"h1":         true,
+	"h2":         true,
+	"h3":         true,
+	"h4":         true,
+	"h5":         true,
+	"h6":         true,
+	"header":     true,
+	"hr":         true,
+	"html":       true,
+	"li":         true,
+	"main":       true,
+	"nav":        true,
+	"ol":         true,
+	"p":          true,
+	"pre":        true,
+	"section":    true,
+	"summary":    true,
+	"table":      true,
+	"ul":         true,
+}
+
+var skippedTags = map[string]bool{
+	"head":     true,
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"iframe":   true,
+	"svg":      true,
+	"button":   true,
+	"select":   true,
+	"input":    true,
+	"textarea": true,
+}
+
+func isBlock(n *html.Node) bool {
+	return n.Type == html.ElementNode && blockTags[n.Data]
+}
+
+func getAttr(n *html.Node, key string) string {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			return n.Attr[i].Val
+		}
+	}
+	return ""
+}
+
+func collapseWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// mdBlocks renders the children of n as a list of Markdown blocks.
+func mdBlocks(n *html.Node) []string {
+	var blocks []string
+	var inline strings.Builder
+
+	flush := func() {
+		lines := strings.Split(inline.String(), "\n")
+		var kept []string
+		for _, line := range lines {
+			line = collapseWhitespace(line)
+			if line != "" {
+				kept = append(kept, line)
+			}
+		}
+		if len(kept) > 0 {
+			blocks = append(blocks, strings.Join(kept, "\n"))
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && skippedTags[c.Data] {
+			continue
+		}
+		if isBlock(c) {
+			flush()
+			blocks = append(blocks, mdBlock(c)...)
+			continue
+		}
+		inline.WriteString(mdInline(c))
+	}
+	flush()
+
+	return blocks
+}
+
+func mdBlock(n *html.Node) []string {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := collapseWhitespace(mdInlineChildren(n))
+		if text == "" {
+			return nil
+		}
+		level := int(n.Data[1] - '0')
+		return []string{strings.Repeat("#", level) + " " + text}
+	case "hr":
+		return []string{"---"}
+	case "pre":
+		return mdCodeBlock(n)
+	case "ul", "ol":
+		return mdList(n)
+	case "blockquote":
+		inner := strings.Join(mdBlocks(n), "\n\n")
+		if inner == "" {
+			return nil
+		}
+		lines := strings.Split(inner, "\n")
+		for i := range lines {
+			if lines[i] == "" {
+				lines[i] = ">"
+			} else {
+				lines[i] = "> " + lines[i]
+			}
+		}
+		return []string{strings.Join(lines, "\n")}
+	case "table":
+		return mdTable(n)
+	case "dt":
+		text := collapseWhitespace(mdInlineChildren(n))
+		if text == "" {
+			return nil
+		}
+		return []string{"**" + text + "**"}
+	}
+
+	return mdBlocks(n)
+}
+
+func mdList(n *html.Node) []string {
+	var lines []string
+	ordinal := 1
+	if v, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		ordinal = v
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(ordinal) + ". "
+			ordinal++
+		}
+
+		item := strings.Join(mdBlocks(c), "\n")
+		if item == "" {
+			continue
+		}
+
+		indent := strings.Repeat(" ", len(marker))
+		for i, line := range strings.Split(item, "\n") {
+			if i == 0 {
+				lines = append(lines, marker+line)
+			} else if line == "" {
+				lines = append(lines, "")
+			} else {
+				lines = append(lines, indent+line)
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+	return []string{strings.Join(lines, "\n")}
+}
+
+// codeLanguage returns the language hint of a code block, looking at the
+// data-language attribute set by distillPipeline and at the usual
+// "language-*" / "lang-*" class names.
+func codeLanguage(n *html.Node) string {
+	if lang := getAttr(n, "data-language"); lang != "" {
+		return lang
+	}
+	for _, class := range strings.Fields(getAttr(n, "class")) {
+		if lang, ok := strings.CutPrefix(class, "language-"); ok {
+			return lang
+		}
+		if lang, ok := strings.CutPrefix(class, "lang-"); ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+func mdCodeBlock(n *html.Node) []string {
+	lang := codeLanguage(n)
+	for c := n.FirstChild; c != nil && lang == ""; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "code" {
+			lang = codeLanguage(c)
+		}
+	}
+
+	code := strings.Trim(textContent(n), "\n")
+	if strings.TrimSpace(code) == "" {
+		return nil
+	}
+
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	return []string{fence + lang + "\n" + code + "\n" + fence}
+}
+
+// maxSpan bounds the colspan and rowspan of a table cell.
+const maxSpan = 64
+
+// cellSpan returns the value of the colspan or rowspan attribute of a table
+// cell.
+func cellSpan(cell *html.Node, key string) int {
+	span, err := strconv.Atoi(getAttr(cell, key))
+	if err != nil || span < 1 {
+		return 1
+	}
+	return min(span, maxSpan)
+}
+
+func mdTable(n *html.Node) []string {
+	var rows [][]string
+	var hasHeader bool
+
+	// Markdown tables have no merged cells: a cell spanning several rows is
+	// repeated in each of them, and a cell spanning several columns is
+	// followed by empty cells, so that the following columns stay aligned
+	// with the header. pending holds, for every column, the cell spanning
+	// down from an earlier row and the number of rows it still covers.
+	type spanned struct {
+		text string
+		rows int
+	}
+	var pending []spanned
+
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "thead", "tbody", "tfoot":
+				collect(c)
+			case "tr":
+				var row []string
+				fill := func() {
+					for col := len(row); col < len(pending) && pending[col].rows > 0; col++ {
+						pending[col].rows--
+						row = append(row, pending[col].text)
+					}
+				}
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode || (cell.Data != "td" && cell.Data != "th") {
+						continue
+					}
+					if cell.Data == "th" && len(rows) == 0 {
+						hasHeader = true
+					}
+					fill()
+					text := strings.ReplaceAll(collapseWhitespace(mdInlineChildren(cell)), "|", `\|`)
+					rowspan := cellSpan(cell, "rowspan")
+					for k := 0; k < cellSpan(cell, "colspan"); k++ {
+						if k > 0 {
+							text = ""
+						}
+						if rowspan > 1 {
+							for len(pending) <= len(row) {
+								pending = append(pending, spanned{})
+							}
+							pending[len(row)] = spanned{text: text, rows: rowspan - 1}
+						}
+						row = append(row, text)
+					}
+				}
+				fill()
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+	collect(n)
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var columns int
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	var sb strings.Builder
+	writeRow := func(row []string) {
+		sb.WriteString("|")
+		for i := 0; i < columns; i++ {
+			sb.WriteString(" ")
+			if i < len(row) {
+				sb.WriteString(row[i])
+			}
+			sb.WriteString(" |")
+		}
+		sb.WriteString("\n")
+	}
+
+	header := rows[0]
+	body := rows[1:]
+	if !hasHeader {
+		header = nil
+		body = rows
+	}
+
+	writeRow(header)
+	sb.WriteString("|")
+	for i := 0; i < columns; i++ {
+		sb.WriteString(" --- |")
+	}
+	sb.WriteString("\n")
+	for _, row := range body {
+		writeRow(row)
+	}
+
+	return []string{strings.TrimSuffix(sb.String(), "\n")}
+}
+
+func mdInlineChildren(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(mdInline(c))
+	}
+	return sb.String()
+}
+
+// mdWrap surrounds the trimmed text with the given marker while keeping the
+// surrounding whitespace outside of it, so that "a<b> b </b>c" renders as
+// "a **b** c" rather than "a** b **c".
+func mdWrap(s, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	var prefix, suffix string
+	if strings.TrimLeft(s, " \n\t") != s {
+		prefix = " "
+	}
+	if strings.TrimRight(s, " \n\t") != s {
+		suffix = " "
+	}
+	return prefix + marker + trimmed + marker + suffix
+}
+
+func mdInline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		if strings.TrimSpace(n.Data) == "" {
+			if n.Data == "" {
+				return ""
+			}
+			return " "
+		}
+		var prefix, suffix string
+		if strings.TrimLeft(n.Data, " \n\t\r\f") != n.Data {
+			prefix = " "
+		}
+		if strings.TrimRight(n.Data, " \n\t\r\f") != n.Data {
+			suffix = " "
+		}
+		return prefix + collapseWhitespace(n.Data) + suffix
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	if skippedTags[n.Data] {
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\n"
+	case "strong", "b":
+		return mdWrap(mdInlineChildren(n), "**")
+	case "em", "i":
+		return mdWrap(mdInlineChildren(n), "*")
+	case "del", "s", "strike":
+		return mdWrap(mdInlineChildren(n), "~~")
+	case "code", "kbd", "samp":
+		code := collapseWhitespace(textContent(n))
+		if code == "" {
+			return ""
+		}
+		fence := "`"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return fence + code + fence
+	case "a":
+		text := collapseWhitespace(mdInlineChildren(n))
+		href := strings.TrimSpace(getAttr(n, "href"))
+		if text == "" {
+			return ""
+		}
+		if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return text
+		}
+		return "[" + text + "](" + href + ")"
+	case "img":
+		alt := collapseWhitespace(getAttr(n, "alt"))
+		if alt == "" {
+			return ""
+		}
+		src := strings.TrimSpace(getAttr(n, "src"))
+		if src == "" || strings.HasPrefix(src, "data:") {
+			return alt
+		}
+		return "![" + alt + "](" + src + ")"
+	}
+
+	return mdInlineChildren(n)
+}
+
+// textContent returns the raw text of n and its descendants without any
+// whitespace normalization.
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "br" {
+			sb.WriteString("\n")
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// ToMarkdown takes an HTML string, narrows it down to the main content when it
+// can be identified with enough confidence, runs the distillPipeline function on
+// the document to remove unnecessary elements and attributes, and converts the
+// result into Markdown.
+//
+// Headings, lists, tables, code blocks (with language hints), links and image
+// alt text are preserved. The rest of the markup is dropped.
+func ToMarkdown(s string) (string, error) {
+	htmlnode, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+	return ToMarkdownNode(htmlnode), nil
+}
+
+// ToMarkdownNode is ToMarkdown for a parsed document. The document is
+// modified in place, so it must not be used for other extractions
+// afterwards.
+func ToMarkdownNode(htmlnode *html.Node) string {
+	extractMainContent(htmlnode)
+	distillPipeline(htmlnode)
+
+	return strings.Join(mdBlocks(htmlnode), "\n\n")
+}
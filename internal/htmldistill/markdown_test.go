@@ -0,0 +1,119 @@
+package htmldistill
+
+import "testing"
+
+func TestToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "headings and paragraphs",
+			html: `<h1>Title</h1><p>First   paragraph.</p><h3>Sub</h3><p>Second</p>`,
+			want: "# Title\n\nFirst paragraph.\n\n### Sub\n\nSecond",
+		},
+		{
+			name: "inline formatting",
+			html: `<p>a<b> bold </b>c <em>em</em> <del>gone</del> <code>x := 1</code></p>`,
+			want: "a **bold** c *em* ~~gone~~ `x := 1`",
+		},
+		{
+			name: "code span with backticks",
+			html: "<p><code>a`b</code></p>",
+			want: "``a`b``",
+		},
+		{
+			name: "links",
+			html: `<p><a href="https://example.com/">Example</a> <a href="#top">Top</a> <a href="javascript:void(0)">JS</a></p>`,
+			want: "[Example](https://example.com/) Top JS",
+		},
+		{
+			name: "images",
+			html: `<p><img src="/a.png" alt="A chart"><img src="data:image/png;base64,AA" alt="Inline"><img src="/b.png"></p>`,
+			want: "![A chart](/a.png)Inline",
+		},
+		{
+			name: "lists",
+			html: `<ul><li>one</li><li>two<ul><li>nested</li></ul></li></ul><ol start="3"><li>three</li><li>four</li></ol>`,
+			want: "- one\n- two\n  - nested\n\n3. three\n4. four",
+		},
+		{
+			name: "blockquote",
+			html: `<blockquote><p>quoted</p><p>twice</p></blockquote>`,
+			want: "> quoted\n>\n> twice",
+		},
+		{
+			name: "line breaks",
+			html: `<p>line one<br>line two</p>`,
+			want: "line one\nline two",
+		},
+		{
+			name: "code block with language",
+			html: "<pre><code class=\"language-go\">func main() {\n\tprintln(1)\n}\n</code></pre>",
+			want: "```go\nfunc main() {\n\tprintln(1)\n}\n```",
+		},
+		{
+			name: "code block containing a fence",
+			html: "<pre>```\ncode\n```</pre>",
+			want: "````\n```\ncode\n```\n````",
+		},
+		{
+			name: "table with header",
+			html: `<table><tr><th>Name</th><th>Value</th></tr><tr><td>a|b</td><td>1</td></tr></table>`,
+			want: "| Name | Value |\n| --- | --- |\n| a\\|b | 1 |",
+		},
+		{
+			name: "table without header",
+			html: `<table><tr><td>a</td><td>b</td></tr></table>`,
+			want: "|  |  |\n| --- | --- |\n| a | b |",
+		},
+		{
+			name: "table with colspan",
+			html: `<table><tr><th>A</th><th>B</th><th>C</th></tr><tr><td colspan="2">wide</td><td>c</td></tr></table>`,
+			want: "| A | B | C |\n| --- | --- | --- |\n| wide |  | c |",
+		},
+		{
+			name: "table with rowspan",
+			html: `<table><tr><th>Region</th><th>City</th><th>Pop.</th></tr>` +
+				`<tr><td rowspan="2">East</td><td>Seoul</td><td>9.4</td></tr>` +
+				`<tr><td>Incheon</td><td>3.0</td></tr>` +
+				`<tr><td>West</td><td>Lyon</td><td rowspan="3">n/a</td></tr>` +
+				`<tr><td>South</td><td>Nice</td></tr></table>`,
+			want: "| Region | City | Pop. |\n| --- | --- | --- |\n" +
+				"| East | Seoul | 9.4 |\n" +
+				"| East | Incheon | 3.0 |\n" +
+				"| West | Lyon | n/a |\n" +
+				"| South | Nice | n/a |",
+		},
+		{
+			name: "table with rowspan and colspan",
+			html: `<table><tr><th>A</th><th>B</th><th>C</th></tr>` +
+				`<tr><td rowspan="2" colspan="2">block</td><td>1</td></tr>` +
+				`<tr><td>2</td></tr></table>`,
+			want: "| A | B | C |\n| --- | --- | --- |\n| block |  | 1 |\n| block |  | 2 |",
+		},
+		{
+			name: "skipped elements",
+			html: `<p>kept</p><script>var x;</script><button>Click</button><style>p{}</style>`,
+			want: "kept",
+		},
+		{
+			name: "definition list",
+			html: `<dl><dt>Term</dt><dd>Definition</dd></dl>`,
+			want: "**Term**\n\nDefinition",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToMarkdown(tt.html)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ToMarkdown() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
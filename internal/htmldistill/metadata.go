@@ -0,0 +1,359 @@
+package htmldistill
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// PageMetadata holds the document level information found in the <head> of a
+// page (title, OpenGraph, Twitter cards), in its JSON-LD blocks and in the
+// <time> tags of its main content.
+type PageMetadata struct {
+	Title         string `json:"title,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Author        string `json:"author,omitempty"`
+	PublishedTime string `json:"published_time,omitempty"`
+	ModifiedTime  string `json:"modified_time,omitempty"`
+	SiteName      string `json:"site_name,omitempty"`
+	CanonicalURL  string `json:"canonical_url,omitempty"`
+	Image         string `json:"image,omitempty"`
+	Type          string `json:"type,omitempty"`
+	Language      string `json:"language,omitempty"`
+
+	Product *ProductMetadata `json:"product,omitempty"` // JSON-LD Product
+	FAQ     []FAQEntry       `json:"faq,omitempty"`     // JSON-LD FAQPage
+}
+
+type ProductMetadata struct {
+	Name         string `json:"name,omitempty"`
+	Brand        string `json:"brand,omitempty"`
+	Price        string `json:"price,omitempty"`
+	Currency     string `json:"currency,omitempty"`
+	Availability string `json:"availability,omitempty"`
+	Rating       string `json:"rating,omitempty"`
+	ReviewCount  string `json:"review_count,omitempty"`
+}
+
+type FAQEntry struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+var articleTypes = map[string]bool{
+	"Article":              true,
+	"NewsArticle":          true,
+	"BlogPosting":          true,
+	"TechArticle":          true,
+	"ScholarlyArticle":     true,
+	"Report":               true,
+	"SocialMediaPosting":   true,
+	"AnalysisNewsArticle":  true,
+	"ReportageNewsArticle": true,
+	"WebPage":              true,
+}
+
+// setIfEmpty stores v in *dst unless a value with higher priority is already set.
+func setIfEmpty(dst *string, v string) {
+	v = collapseWhitespace(v)
+	if *dst == "" && v != "" {
+		*dst = v
+	}
+}
+
+// ExtractMetadata takes an HTML string and the URL it was fetched from and
+// returns the metadata of the page. JSON-LD takes precedence over OpenGraph,
+// which takes precedence over Twitter cards and plain <meta> tags.
+//
+// ExtractMetadata must be given the raw HTML, as distillation drops most of
+// the <head>.
+func ExtractMetadata(s string, pageURL string) (*PageMetadata, error) {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return nil, err
+	}
+	return ExtractMetadataNode(doc, pageURL), nil
+}
+
+// ExtractMetadataNode is ExtractMetadata for a parsed document, which it
+// leaves untouched.
+func ExtractMetadataNode(doc *html.Node, pageURL string) *PageMetadata {
+	var md PageMetadata
+	var titleTag, canonical, publishedTime string
+	meta := make(map[string]string)
+	var jsonld []string
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "html":
+				lang, _, _ := strings.Cut(getAttr(n, "lang"), "-")
+				md.Language = strings.ToLower(strings.TrimSpace(lang))
+			case "title":
+				if titleTag == "" {
+					titleTag = textContent(n)
+				}
+			case "meta":
+				key := strings.ToLower(getAttr(n, "property"))
+				if key == "" {
+					key = strings.ToLower(getAttr(n, "name"))
+				}
+				if key == "" {
+					key = strings.ToLower(getAttr(n, "itemprop"))
+				}
+				if _, ok := meta[key]; key != "" && !ok {
+					meta[key] = getAttr(n, "content")
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(getAttr(n, "rel"))) {
+					if rel == "canonical" && canonical == "" {
+						canonical = getAttr(n, "href")
+					}
+				}
+			case "script":
+				if strings.EqualFold(strings.TrimSpace(getAttr(n, "type")), "application/ld+json") {
+					jsonld = append(jsonld, textContent(n))
+				}
+				return
+			case "time":
+				if publishedTime == "" && (getAttr(n, "itemprop") == "datePublished" || hasAttr(n, "pubdate")) {
+					publishedTime = timeValue(n)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	for _, block := range jsonld {
+		var v any
+		if json.Unmarshal([]byte(block), &v) != nil {
+			continue
+		}
+		applyJSONLD(&md, v)
+	}
+
+	setIfEmpty(&md.Title, meta["og:title"])
+	setIfEmpty(&md.Title, meta["twitter:title"])
+	setIfEmpty(&md.Title, titleTag)
+
+	setIfEmpty(&md.Description, meta["og:description"])
+	setIfEmpty(&md.Description, meta["twitter:description"])
+	setIfEmpty(&md.Description, meta["description"])
+
+	setIfEmpty(&md.Author, meta["article:author"])
+	setIfEmpty(&md.Author, meta["author"])
+	setIfEmpty(&md.Author, meta["twitter:creator"])
+
+	setIfEmpty(&md.PublishedTime, meta["article:published_time"])
+	setIfEmpty(&md.PublishedTime, meta["datepublished"])
+	setIfEmpty(&md.PublishedTime, publishedTime)
+	if md.PublishedTime == "" {
+		// Other <time> tags are only trusted inside the main content, as
+		// sidebars and comment threads are full of unrelated dates.
+		_, selected, _ := findMainContent(doc)
+		for _, block := range selected {
+			if n := findElement(block, "time"); n != nil {
+				setIfEmpty(&md.PublishedTime, timeValue(n))
+				break
+			}
+		}
+	}
+
+	setIfEmpty(&md.ModifiedTime, meta["article:modified_time"])
+	setIfEmpty(&md.ModifiedTime, meta["og:updated_time"])
+	setIfEmpty(&md.ModifiedTime, meta["datemodified"])
+
+	setIfEmpty(&md.SiteName, meta["og:site_name"])
+	setIfEmpty(&md.SiteName, meta["application-name"])
+	setIfEmpty(&md.SiteName, meta["twitter:site"])
+
+	setIfEmpty(&md.Image, meta["og:image"])
+	setIfEmpty(&md.Image, meta["twitter:image"])
+
+	setIfEmpty(&md.Type, meta["og:type"])
+
+	setIfEmpty(&md.CanonicalURL, canonical)
+	setIfEmpty(&md.CanonicalURL, meta["og:url"])
+	md.CanonicalURL = resolveURL(pageURL, md.CanonicalURL)
+	md.Image = resolveURL(pageURL, md.Image)
+
+	return &md
+}
+
+// timeValue returns the datetime attribute of a <time> tag, or its text.
+func timeValue(n *html.Node) string {
+	if datetime := getAttr(n, "datetime"); datetime != "" {
+		return datetime
+	}
+	return textContent(n)
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveURL(base, ref string) string {
+	if ref == "" || base == "" {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+func applyJSONLD(md *PageMetadata, v any) {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			applyJSONLD(md, item)
+		}
+		return
+	case map[string]any:
+		if graph, ok := v["@graph"]; ok {
+			applyJSONLD(md, graph)
+		}
+
+		for _, typ := range jsonldTypes(v["@type"]) {
+			switch {
+			case articleTypes[typ]:
+				if typ != "WebPage" {
+					setIfEmpty(&md.Type, typ)
+				}
+				setIfEmpty(&md.Title, jsonldString(v["headline"]))
+				setIfEmpty(&md.Title, jsonldString(v["name"]))
+				setIfEmpty(&md.Description, jsonldString(v["description"]))
+				setIfEmpty(&md.Author, jsonldString(v["author"]))
+				setIfEmpty(&md.PublishedTime, jsonldString(v["datePublished"]))
+				setIfEmpty(&md.ModifiedTime, jsonldString(v["dateModified"]))
+				setIfEmpty(&md.SiteName, jsonldString(v["publisher"]))
+				setIfEmpty(&md.Image, jsonldFirst(v["image"]))
+			case typ == "Product":
+				setIfEmpty(&md.Type, typ)
+				if md.Product == nil {
+					md.Product = &ProductMetadata{}
+				}
+				setIfEmpty(&md.Product.Name, jsonldString(v["name"]))
+				setIfEmpty(&md.Product.Brand, jsonldString(v["brand"]))
+				setIfEmpty(&md.Description, jsonldString(v["description"]))
+				setIfEmpty(&md.Image, jsonldFirst(v["image"]))
+
+				offers := v["offers"]
+				if list, ok := offers.([]any); ok && len(list) > 0 {
+					offers = list[0]
+				}
+				if offer, ok := offers.(map[string]any); ok {
+					setIfEmpty(&md.Product.Price, jsonldString(offer["price"]))
+					setIfEmpty(&md.Product.Price, jsonldString(offer["lowPrice"]))
+					setIfEmpty(&md.Product.Currency, jsonldString(offer["priceCurrency"]))
+					availability := jsonldString(offer["availability"])
+					availability = strings.TrimPrefix(availability, "https://schema.org/")
+					availability = strings.TrimPrefix(availability, "http://schema.org/")
+					setIfEmpty(&md.Product.Availability, availability)
+				}
+				if rating, ok := v["aggregateRating"].(map[string]any); ok {
+					setIfEmpty(&md.Product.Rating, jsonldString(rating["ratingValue"]))
+					setIfEmpty(&md.Product.ReviewCount, jsonldString(rating["reviewCount"]))
+					setIfEmpty(&md.Product.ReviewCount, jsonldString(rating["ratingCount"]))
+				}
+			case typ == "FAQPage":
+				setIfEmpty(&md.Type, typ)
+				entities, ok := v["mainEntity"].([]any)
+				if !ok {
+					if entity, isMap := v["mainEntity"].(map[string]any); isMap {
+						entities = []any{entity}
+					}
+				}
+				for _, entity := range entities {
+					q, ok := entity.(map[string]any)
+					if !ok {
+						continue
+					}
+					question := collapseWhitespace(jsonldString(q["name"]))
+					answer := collapseWhitespace(jsonldString(q["acceptedAnswer"]))
+					if question == "" || answer == "" {
+						continue
+					}
+					md.FAQ = append(md.FAQ, FAQEntry{Question: question, Answer: answer})
+				}
+			case typ == "Organization" || typ == "WebSite":
+				setIfEmpty(&md.SiteName, jsonldString(v["name"]))
+			}
+		}
+	}
+}
+
+// jsonldFirst is like jsonldString but only keeps the first element of a list.
+func jsonldFirst(v any) string {
+	if list, ok := v.([]any); ok {
+		if len(list) == 0 {
+			return ""
+		}
+		return jsonldString(list[0])
+	}
+	return jsonldString(v)
+}
+
+func jsonldTypes(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var types []string
+		for _, t := range v {
+			if s, ok := t.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// jsonldString flattens a JSON-LD value into a string. Objects are reduced to
+// their name, url or text, and lists are joined with commas.
+func jsonldString(v any) string {
+	switch v := v.(type) {
+	case string:
+		if strings.ContainsAny(v, "<>") {
+			if text, err := ExtractText(v); err == nil {
+				return collapseWhitespace(text)
+			}
+		}
+		return v
+	case float64:
+		return fmt.Sprint(v)
+	case []any:
+		var parts []string
+		for _, item := range v {
+			if s := jsonldString(item); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	case map[string]any:
+		for _, key := range []string{"name", "text", "url", "@id"} {
+			if s := jsonldString(v[key]); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package htmldistill
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	article := strings.Repeat("<p>"+longParagraph+"</p>", 5)
+
+	tests := []struct {
+		name string
+		html string
+		url  string
+		want PageMetadata
+	}{
+		{
+			name: "head tags",
+			html: `<html lang="en-US"><head><title>Tag title</title>
+<meta property="og:title" content="OG title">
+<meta name="twitter:title" content="Twitter title">
+<meta name="description" content="Plain description">
+<meta name="author" content="Jane Doe">
+<meta property="og:site_name" content="Example News">
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:type" content="article">
+<link rel="canonical" href="/news/1">
+</head><body></body></html>`,
+			url: "https://example.com/news/1?utm=x",
+			want: PageMetadata{
+				Title:        "OG title",
+				Description:  "Plain description",
+				Author:       "Jane Doe",
+				SiteName:     "Example News",
+				CanonicalURL: "https://example.com/news/1",
+				Image:        "https://example.com/img/cover.png",
+				Type:         "article",
+				Language:     "en",
+			},
+		},
+		{
+			name: "JSON-LD takes precedence",
+			html: `<head><meta property="og:title" content="OG title">
+<script type="application/ld+json">{"@context":"https://schema.org","@graph":[
+{"@type":"NewsArticle","headline":"LD headline","author":[{"@type":"Person","name":"A"},{"@type":"Person","name":"B"}],
+"datePublished":"2024-03-01T10:00:00Z","publisher":{"@type":"Organization","name":"LD News"}}]}</script></head>`,
+			want: PageMetadata{
+				Title:         "LD headline",
+				Author:        "A, B",
+				PublishedTime: "2024-03-01T10:00:00Z",
+				SiteName:      "LD News",
+				Type:          "NewsArticle",
+			},
+		},
+		{
+			name: "product",
+			html: `<script type="application/ld+json">{"@type":"Product","name":"Widget","brand":{"name":"Acme"},
+"offers":[{"price":19.99,"priceCurrency":"USD","availability":"https://schema.org/InStock"}],
+"aggregateRating":{"ratingValue":"4.5","reviewCount":"12"}}</script>`,
+			want: PageMetadata{
+				Type: "Product",
+				Product: &ProductMetadata{
+					Name:         "Widget",
+					Brand:        "Acme",
+					Price:        "19.99",
+					Currency:     "USD",
+					Availability: "InStock",
+					Rating:       "4.5",
+					ReviewCount:  "12",
+				},
+			},
+		},
+		{
+			name: "FAQ",
+			html: `<script type="application/ld+json">{"@type":"FAQPage","mainEntity":[
+{"@type":"Question","name":"Why?","acceptedAnswer":{"@type":"Answer","text":"<p>Because.</p>"}},
+{"@type":"Question","name":"Empty"}]}</script>`,
+			want: PageMetadata{
+				Type: "FAQPage",
+				FAQ:  []FAQEntry{{Question: "Why?", Answer: "Because."}},
+			},
+		},
+		{
+			name: "invalid JSON-LD is ignored",
+			html: `<title>Title</title><script type="application/ld+json">{"@type":</script>`,
+			want: PageMetadata{Title: "Title"},
+		},
+		{
+			name: "published time tag",
+			html: `<body><div class="sidebar"><time datetime="2020-01-01">old</time></div><p>Posted <time pubdate datetime="2024-05-06">May 6</time></p></body>`,
+			want: PageMetadata{PublishedTime: "2024-05-06"},
+		},
+		{
+			name: "datePublished time tag",
+			html: `<body><time datetime="2020-01-01">old</time><time itemprop="datePublished">2024-05-06</time></body>`,
+			want: PageMetadata{PublishedTime: "2024-05-06"},
+		},
+		{
+			name: "time tag outside the main content",
+			html: `<body><div class="sidebar"><ul><li><a href="/a">Other story</a> <time datetime="2020-01-01">Jan 1</time></li></ul></div><article>` + article + `</article></body>`,
+			want: PageMetadata{},
+		},
+		{
+			name: "time tag in the main content",
+			html: `<body><div class="sidebar"><ul><li><a href="/a">Other story</a> <time datetime="2020-01-01">Jan 1</time></li></ul></div><article><p>By Jane, <time datetime="2024-05-06">May 6</time></p>` + article + `</article></body>`,
+			want: PageMetadata{PublishedTime: "2024-05-06"},
+		},
+		{
+			name: "time tag without main content",
+			html: `<body><p>Updated <time datetime="2024-05-06">May 6</time></p></body>`,
+			want: PageMetadata{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractMetadata(tt.html, tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ExtractMetadata() =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+}
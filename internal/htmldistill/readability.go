@@ -0,0 +1,292 @@
+package htmldistill
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// The main-content scorer follows the usual readability approach: every
+// paragraph-like element adds a score to its parent and grandparent based on
+// the amount of text it holds, the resulting candidates are penalized by their
+// link density, and the best candidate (plus any related siblings) replaces
+// the body of the document.
+
+var (
+	negativeHints = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|consent|cookie|disqus|extra|foot|gdpr|header|legends|menu|modal|nav|newsletter|outbrain|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|tags|taboola|tool|widget`)
+	positiveHints = regexp.MustCompile(`(?i)article|body|blog|content|entry|h-entry|main|page|post|story|text`)
+)
+
+var scorableTags = map[string]bool{
+	"p":          true,
+	"pre":        true,
+	"td":         true,
+	"blockquote": true,
+	"section":    true,
+	"div":        true,
+}
+
+var ignoredTextTags = map[string]bool{
+	"head":     true,
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+}
+
+var boilerplateTags = map[string]bool{
+	"nav":    true,
+	"aside":  true,
+	"footer": true,
+	"form":   true,
+	"dialog": true,
+}
+
+const (
+	// minParagraphLength is the minimum text length of an element for it to
+	// contribute to the score of its ancestors.
+	minParagraphLength = 25
+	// minContentLength is the minimum text length of the selected main content.
+	// Below this, the whole page is kept.
+	minContentLength = 250
+	// minContentScore is the minimum score of the selected main content.
+	// Below this, the whole page is kept.
+	minContentScore = 20
+)
+
+type nodeStats struct {
+	text     int
+	linkText int
+	commas   int
+}
+
+func (s *nodeStats) linkDensity() float64 {
+	if s.text == 0 {
+		return 0
+	}
+	return float64(s.linkText) / float64(s.text)
+}
+
+func collectStats(n *html.Node, stats map[*html.Node]*nodeStats) nodeStats {
+	var st nodeStats
+
+	switch n.Type {
+	case html.TextNode:
+		text := collapseWhitespace(n.Data)
+		return nodeStats{text: len(text), commas: strings.Count(text, ",")}
+	case html.ElementNode:
+		if ignoredTextTags[n.Data] {
+			return st
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		cs := collectStats(c, stats)
+		st.text += cs.text
+		st.linkText += cs.linkText
+		st.commas += cs.commas
+	}
+	if n.Type == html.ElementNode && n.Data == "a" {
+		st.linkText = st.text
+	}
+
+	stats[n] = &st
+	return st
+}
+
+func classWeight(n *html.Node) float64 {
+	var weight float64
+	for _, key := range []string{"class", "id"} {
+		v := getAttr(n, key)
+		if v == "" {
+			continue
+		}
+		if negativeHints.MatchString(v) {
+			weight -= 25
+		}
+		if positiveHints.MatchString(v) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+func initialScore(n *html.Node) float64 {
+	score := classWeight(n)
+	switch n.Data {
+	case "article", "main":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	case "nav", "aside", "footer", "header":
+		score -= 25
+	}
+	return score
+}
+
+func hasBlockChildren(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if isBlock(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// extractMainContent replaces the contents of the document body with the
+// main article block. It reports whether the main content was identified with
+// enough confidence; if not, the document is left untouched.
+func extractMainContent(doc *html.Node) bool {
+	body, selected, stats := findMainContent(doc)
+	if selected == nil {
+		return false
+	}
+
+	for c := body.FirstChild; c != nil; {
+		next := c.NextSibling
+		body.RemoveChild(c)
+		c = next
+	}
+	for _, n := range selected {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+		removeBoilerplate(n, stats)
+		body.AppendChild(n)
+	}
+
+	return true
+}
+
+// findMainContent finds the blocks of the main article of the document
+// without modifying it. selected is nil if the main content cannot be
+// identified with enough confidence.
+func findMainContent(doc *html.Node) (body *html.Node, selected []*html.Node, stats map[*html.Node]*nodeStats) {
+	body = findElement(doc, "body")
+	if body == nil {
+		return nil, nil, nil
+	}
+
+	stats = make(map[*html.Node]*nodeStats)
+	collectStats(body, stats)
+
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		// Ancestors of the body (the grandparent of a paragraph directly
+		// under it) have no stats and are not candidates.
+		if n == nil || n.Type != html.ElementNode || stats[n] == nil {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if ignoredTextTags[n.Data] {
+				return
+			}
+			if scorableTags[n.Data] && (n.Data == "p" || n.Data == "pre" || !hasBlockChildren(n)) {
+				if st := stats[n]; st != nil && st.text >= minParagraphLength {
+					score := 1 + float64(st.commas) + min(float64(st.text)/100, 3)
+					addScore(n.Parent, score)
+					if n.Parent != nil {
+						addScore(n.Parent.Parent, score/2)
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	var top *html.Node
+	var topScore float64
+	for _, n := range candidates {
+		score := scores[n] * (1 - stats[n].linkDensity())
+		scores[n] = score
+		if top == nil || score > topScore {
+			top, topScore = n, score
+		}
+	}
+
+	if top == nil || top == body || top.Data == "html" {
+		return body, nil, stats
+	}
+	if topScore < minContentScore || stats[top].text < minContentLength {
+		return body, nil, stats
+	}
+
+	// Related content is sometimes split across siblings of the top
+	// candidate (e.g. the article header and the article body).
+	threshold := max(10, topScore*0.2)
+	for c := top.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if c == top {
+			selected = append(selected, c)
+			continue
+		}
+		if c.Type != html.ElementNode || boilerplateTags[c.Data] {
+			continue
+		}
+		st := stats[c]
+		if st == nil {
+			continue
+		}
+		if score, ok := scores[c]; ok && score >= threshold {
+			selected = append(selected, c)
+			continue
+		}
+		if c.Data == "p" && st.text > 80 && st.linkDensity() < 0.25 {
+			selected = append(selected, c)
+		}
+	}
+	return body, selected, stats
+}
+
+// removeBoilerplate drops navigation blocks and link-heavy, negatively hinted
+// elements that are nested inside the main content.
+func removeBoilerplate(n *html.Node, stats map[*html.Node]*nodeStats) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if boilerplateTags[c.Data] {
+			n.RemoveChild(c)
+			continue
+		}
+		if st := stats[c]; st != nil && classWeight(c) < 0 && st.linkDensity() > 0.33 {
+			n.RemoveChild(c)
+			continue
+		}
+		removeBoilerplate(c, stats)
+	}
+}
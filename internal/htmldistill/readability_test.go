@@ -0,0 +1,93 @@
+package htmldistill
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const longParagraph = "The committee met on Tuesday to discuss the budget, the schedule, and the staffing plan for next year."
+
+func TestExtractMainContent(t *testing.T) {
+	article := strings.Repeat("<p>"+longParagraph+"</p>", 5)
+
+	tests := []struct {
+		name      string
+		html      string
+		extracted bool
+		contains  []string
+		excludes  []string
+	}{
+		{
+			// Regression: the grandparent of a paragraph directly under
+			// <body> is <html>, which has no stats.
+			name:     "paragraphs directly under body",
+			html:     "<html><body>" + article + "</body></html>",
+			contains: []string{"committee met"},
+		},
+		{
+			name:      "article among boilerplate",
+			html:      `<body><nav><a href="/">Home</a><a href="/news">News</a></nav><div class="sidebar"><a href="/a">Related story with a long enough title</a></div><article><h1>Budget</h1>` + article + `</article><footer>Copyright 2024 Example Corp, all rights reserved</footer></body>`,
+			extracted: true,
+			contains:  []string{"committee met", "Budget"},
+			excludes:  []string{"Home", "Related story", "Copyright"},
+		},
+		{
+			name:      "boilerplate nested in content",
+			html:      `<body><div class="post">` + article + `<div class="share"><a href="/fb">Share on Facebook</a> <a href="/tw">Share on Twitter</a></div><aside>Aside text</aside></div></body>`,
+			extracted: true,
+			contains:  []string{"committee met"},
+			excludes:  []string{"Share on", "Aside text"},
+		},
+		{
+			name:     "short page kept whole",
+			html:     `<body><nav><a href="/">Home</a></nav><div><p>` + longParagraph + `</p></div></body>`,
+			contains: []string{"Home", "committee met"},
+		},
+		{
+			name: "no body",
+			html: `<p>` + longParagraph + `</p>`,
+			// html.Parse always adds a body.
+			contains: []string{"committee met"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := extractMainContent(doc); got != tt.extracted {
+				t.Errorf("extractMainContent() = %v, want %v", got, tt.extracted)
+			}
+
+			var sb strings.Builder
+			if err := html.Render(&sb, doc); err != nil {
+				t.Fatal(err)
+			}
+			out := sb.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output does not contain %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(out, s) {
+					t.Errorf("output contains %q:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanParagraphUnderBody(t *testing.T) {
+	out, err := Clean("<html><body><p>" + longParagraph + "</p></body></html>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, longParagraph) {
+		t.Errorf("Clean() = %q, want the paragraph kept", out)
+	}
+}
@@ -0,0 +1,128 @@
+package langdetect
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxSample is the number of runes looked at by Detect.
+const maxSample = 4096
+
+var scripts = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Greek, "el"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// stopwords are frequent short words of languages written in Latin script.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "for", "with", "are", "this", "it"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "ein", "eine", "auf", "für", "den"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "dans", "pour", "que", "pas", "du"},
+	"es": {"el", "la", "los", "las", "y", "es", "una", "por", "para", "que", "con", "del"},
+	"pt": {"o", "os", "as", "e", "é", "uma", "não", "para", "com", "que", "do", "da"},
+	"it": {"il", "gli", "e", "è", "una", "per", "che", "non", "con", "del", "della", "sono"},
+	"nl": {"de", "het", "een", "en", "is", "van", "niet", "met", "voor", "dat", "op", "zijn"},
+}
+
+var stopwordIndex = func() map[string][]string {
+	index := make(map[string][]string)
+	for lang, words := range stopwords {
+		for _, w := range words {
+			index[w] = append(index[w], lang)
+		}
+	}
+	return index
+}()
+
+// Detect returns the two-letter code of the language s is most likely
+// written in, or an empty string if s has no letters.
+//
+// Non-Latin languages are told apart by their script (Hangul is Korean, Kana
+// is Japanese, Han alone is Chinese, ...), and Latin-script languages by their
+// most frequent words. Latin text that matches no known word list is reported
+// as English.
+func Detect(s string) string {
+	counts := make(map[string]int)
+	var latin, letters, n int
+	for _, r := range s {
+		if n++; n > maxSample {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, script := range scripts {
+			if unicode.Is(script.table, r) {
+				counts[script.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese text mixes Kana with Han, and Korean text sometimes contains
+	// Hanja, so a small amount of Kana or Hangul decides over Han.
+	if counts["ja"] > 0 && counts["ja"]*10 >= counts["zh"] {
+		counts["ja"] += counts["zh"]
+		counts["zh"] = 0
+	}
+	if counts["ko"] > 0 && counts["ko"]*10 >= counts["zh"] {
+		counts["ko"] += counts["zh"]
+		counts["zh"] = 0
+	}
+
+	var best string
+	var bestCount int
+	for _, script := range scripts {
+		if c := counts[script.lang]; c > bestCount {
+			best, bestCount = script.lang, c
+		}
+	}
+	if bestCount > latin {
+		return best
+	}
+
+	return detectLatin(s)
+}
+
+func detectLatin(s string) string {
+	if len(s) > maxSample*2 {
+		s = s[:maxSample*2]
+	}
+
+	scores := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, w := range words {
+		for _, lang := range stopwordIndex[w] {
+			scores[lang]++
+		}
+	}
+
+	best, bestScore := "en", 0
+	for _, lang := range []string{"en", "de", "fr", "es", "pt", "it", "nl"} {
+		if scores[lang] > bestScore {
+			best, bestScore = lang, scores[lang]
+		}
+	}
+	return best
+}
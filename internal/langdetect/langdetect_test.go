@@ -0,0 +1,42 @@
+package langdetect
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"empty", "", ""},
+		{"no letters", "123 456 !?", ""},
+		{"Korean", "서울은 대한민국의 수도이다.", "ko"},
+		{"Korean with Hanja", "大韓民國 憲法 제1조 대한민국은 민주공화국이다.", "ko"},
+		{"Japanese", "東京は日本の首都です。", "ja"},
+		{"Japanese mostly Han", "日本国憲法第九条の解釈について", "ja"},
+		{"Chinese", "北京是中国的首都。", "zh"},
+		{"Russian", "Москва является столицей России.", "ru"},
+		{"Greek", "Η Αθήνα είναι η πρωτεύουσα της Ελλάδας.", "el"},
+		{"Arabic", "القاهرة هي عاصمة مصر.", "ar"},
+		{"Thai", "กรุงเทพมหานครเป็นเมืองหลวงของประเทศไทย", "th"},
+		{"English", "The capital of France is Paris, and it is known for the Eiffel Tower.", "en"},
+		{"German", "Die Hauptstadt von Deutschland ist Berlin und nicht Bonn.", "de"},
+		{"French", "La capitale de la France est Paris et pas Lyon.", "fr"},
+		{"Spanish", "La capital de España es Madrid y es una ciudad con muchos museos.", "es"},
+		{"Dutch", "De hoofdstad van Nederland is Amsterdam en niet Den Haag.", "nl"},
+		{"unknown Latin is English", "Lorem ipsum dolor sit amet", "en"},
+		{"mostly Latin with a few Han", "Go 言語 is a programming language designed at Google for building software.", "en"},
+		{"long text is sampled", strings.Repeat("서울 ", 3000) + strings.Repeat("the cat ", 3000), "ko"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.s); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package passage
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/bm25"
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/htmldistill"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+	"github.com/lemon-mint/infofluss/internal/tokens"
+)
+
+// DefaultPassageTokens is the target size of a passage.
+const DefaultPassageTokens = 384
+
+// separator is inserted between non-adjacent passages of the same document.
+const separator = "\n\n[...]\n\n"
+
+// Split cuts text into passages of about maxTokens tokens. Paragraphs are
+// kept together whenever possible, and every passage is prefixed with the
+// closest Markdown heading above it so that it can be understood on its own.
+func Split(text string, maxTokens int) []string {
+	if maxTokens <= 0 {
+		maxTokens = DefaultPassageTokens
+	}
+
+	var passages []string
+	var current []string
+	var currentTokens int
+	var heading, currentHeading string
+
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		if len(current) == 1 && current[0] == currentHeading {
+			// A heading on its own is repeated above the next passage.
+			current = current[:0]
+			currentTokens = 0
+			return
+		}
+		p := strings.Join(current, "\n\n")
+		if currentHeading != "" && !strings.HasPrefix(p, currentHeading) {
+			p = currentHeading + "\n\n" + p
+		}
+		passages = append(passages, p)
+		current = current[:0]
+		currentTokens = 0
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		if strings.HasPrefix(paragraph, "#") && !strings.Contains(paragraph, "\n") {
+			flush()
+			heading = paragraph
+		}
+
+		n := tokens.Estimate(paragraph)
+		if currentTokens > 0 && currentTokens+n > maxTokens {
+			flush()
+		}
+		if len(current) == 0 {
+			currentHeading = heading
+		}
+
+		for n > maxTokens {
+			head := tokens.Truncate(paragraph, maxTokens)
+			if head == "" {
+				break
+			}
+			current = append(current, strings.TrimSpace(head))
+			flush()
+			currentHeading = heading
+			paragraph = strings.TrimSpace(paragraph[len(head):])
+			n = tokens.Estimate(paragraph)
+		}
+		if paragraph == "" {
+			continue
+		}
+
+		current = append(current, paragraph)
+		currentTokens += n
+	}
+	flush()
+
+	return passages
+}
+
+type passage struct {
+	document int
+	order    int
+	text     string
+	tokens   int
+}
+
+// Text returns the plain text of a document, or false if the document is not
+// text (e.g. a page screenshot).
+func Text(doc chat.Document) (string, bool) {
+	text, _, ok := documentText(doc)
+	return text, ok
+}
+
+// documentText returns the text of a document suitable for splitting, and
+// the format of that text.
+func documentText(doc chat.Document) (string, chat.DocumentFormat, bool) {
+	var sb strings.Builder
+	for _, part := range doc.Contents {
+		text, ok := part.(llm.Text)
+		if !ok {
+			return "", "", false
+		}
+		sb.WriteString(string(text))
+	}
+
+	if doc.Format == chat.DocumentFormatHTML {
+		text, err := htmldistill.ExtractText(sb.String())
+		if err != nil {
+			return "", "", false
+		}
+		// ExtractText puts every text node on its own line; treat lines as
+		// paragraphs so that Split has boundaries to work with.
+		return strings.ReplaceAll(text, "\n", "\n\n"), chat.DocumentFormatText, true
+	}
+
+	return sb.String(), doc.Format, true
+}
+
+// Queries returns the retrieval queries for a query plan: the user query and
+// every search query together with the description of what it should find.
+func Queries(query string, plan *queryplan.QueryPlan) []string {
+	queries := []string{query}
+	if plan != nil {
+		for _, q := range plan.SearchQueries {
+			queries = append(queries, q.Query+" "+q.Description)
+		}
+	}
+	return queries
+}
+
+// Select splits documents into passages and keeps only the passages most
+// relevant to queries, using BM25, within a total budget of tokens.
+//
+// Passages are picked in turn for every query so that each sub-query gets
+// supporting material. The selected passages are returned grouped in their
+// original documents and order; documents without any selected passage are
+// dropped. If no passage matches the queries, the leading passages of the
+// documents are selected in rank order instead. Documents that are not text
+// (e.g. page screenshots) are kept whole and count against the budget.
+func Select(documents []chat.Document, queries []string, budget, passageTokens int) []chat.Document {
+	if budget <= 0 {
+		budget = chat.DefaultTokenBudget
+	}
+
+	var passages []passage
+	formats := make([]chat.DocumentFormat, len(documents))
+	keepWhole := make([]bool, len(documents))
+	for i, doc := range documents {
+		text, format, ok := documentText(doc)
+		if !ok {
+			keepWhole[i] = true
+			budget -= tokens.EstimateSegments(doc.Contents)
+			continue
+		}
+		formats[i] = format
+		for j, p := range Split(text, passageTokens) {
+			passages = append(passages, passage{
+				document: i,
+				order:    j,
+				text:     p,
+				tokens:   tokens.Estimate(p),
+			})
+		}
+	}
+
+	texts := make([]string, len(passages))
+	for i := range passages {
+		texts[i] = passages[i].text
+	}
+	index := bm25.New(texts)
+
+	rankings := make([][]int, len(queries))
+	for i, query := range queries {
+		scores := index.Score(query)
+		var ranking []int
+		for j := range scores {
+			if scores[j] > 0 {
+				ranking = append(ranking, j)
+			}
+		}
+		sort.SliceStable(ranking, func(a, b int) bool {
+			return scores[ranking[a]] > scores[ranking[b]]
+		})
+		rankings[i] = ranking
+	}
+
+	selected := make([]bool, len(passages))
+	initialBudget := budget
+	cursor := make([]int, len(queries))
+	for budget > 0 {
+		progress := false
+		for i, ranking := range rankings {
+			for cursor[i] < len(ranking) {
+				p := ranking[cursor[i]]
+				cursor[i]++
+				if selected[p] || passages[p].tokens > budget {
+					continue
+				}
+				selected[p] = true
+				budget -= passages[p].tokens
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+
+	if budget == initialBudget {
+		// No passage matches the queries (e.g. pages in another language, or
+		// queries made of stop words): fall back to the leading passages of
+		// the documents, in rank order, rather than to no document at all.
+		leading := make([]int, len(passages))
+		for i := range leading {
+			leading[i] = i
+		}
+		sort.SliceStable(leading, func(a, b int) bool {
+			return passages[leading[a]].order < passages[leading[b]].order
+		})
+		for _, p := range leading {
+			if passages[p].tokens <= budget {
+				selected[p] = true
+				budget -= passages[p].tokens
+			}
+		}
+	}
+
+	chosen := make([][]passage, len(documents))
+	for i, p := range passages {
+		if selected[i] {
+			chosen[p.document] = append(chosen[p.document], p)
+		}
+	}
+
+	var result []chat.Document
+	for i, doc := range documents {
+		if keepWhole[i] {
+			result = append(result, doc)
+			continue
+		}
+		if len(chosen[i]) == 0 {
+			continue
+		}
+
+		var sb strings.Builder
+		for j, p := range chosen[i] {
+			if j > 0 {
+				if p.order == chosen[i][j-1].order+1 {
+					sb.WriteString("\n\n")
+				} else {
+					sb.WriteString(separator)
+				}
+			}
+			sb.WriteString(p.text)
+		}
+
+		doc.Format = formats[i]
+		doc.Contents = []llm.Segment{llm.Text(sb.String())}
+		result = append(result, doc)
+	}
+
+	return result
+}
@@ -0,0 +1,177 @@
+package passage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+)
+
+func TestSplit(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		want      []string
+	}{
+		{
+			name:      "paragraphs kept together",
+			text:      "one\n\ntwo\n\nthree",
+			maxTokens: 100,
+			want:      []string{"one\n\ntwo\n\nthree"},
+		},
+		{
+			name:      "heading prefixes its passages",
+			text:      "intro text here\n\n# Heading\n\nfirst paragraph is long\n\nsecond paragraph is long",
+			maxTokens: 8,
+			want: []string{
+				"intro text here",
+				"# Heading\n\nfirst paragraph is long",
+				"# Heading\n\nsecond paragraph is long",
+			},
+		},
+		{
+			name:      "long paragraph is cut",
+			text:      strings.TrimSpace(long),
+			maxTokens: 20,
+			want: []string{
+				strings.TrimSpace(long[:80]),
+				strings.TrimSpace(long[80:]),
+			},
+		},
+		{
+			name: "empty",
+			text: "\n\n  \n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Split(tt.text, tt.maxTokens); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Split() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func markdown(source string, paragraphs ...string) chat.Document {
+	return chat.Document{
+		Source:   source,
+		Format:   chat.DocumentFormatMarkdown,
+		Contents: []llm.Segment{llm.Text(strings.Join(paragraphs, "\n\n"))},
+	}
+}
+
+func text(doc chat.Document) string {
+	var sb strings.Builder
+	for _, part := range doc.Contents {
+		if t, ok := part.(llm.Text); ok {
+			sb.WriteString(string(t))
+		}
+	}
+	return sb.String()
+}
+
+func TestSelect(t *testing.T) {
+	screenshot := chat.Document{Source: "image", Contents: []llm.Segment{&llm.InlineData{}}}
+
+	tests := []struct {
+		name      string
+		documents []chat.Document
+		queries   []string
+		budget    int
+		want      map[string]string // source -> selected text
+	}{
+		{
+			name: "relevant passages only",
+			documents: []chat.Document{
+				markdown("a", "Cats sleep most of the day.", "Dogs bark at strangers.", "Cats purr when happy."),
+				markdown("b", "The stock market fell today."),
+			},
+			queries: []string{"cats"},
+			budget:  1000,
+			want:    map[string]string{"a": "Cats sleep most of the day." + separator + "Cats purr when happy."},
+		},
+		{
+			name: "every query gets passages",
+			documents: []chat.Document{
+				markdown("a", "Cats sleep most of the day.", "Cats purr when happy."),
+				markdown("b", "Dogs bark at strangers."),
+			},
+			queries: []string{"cats", "dogs"},
+			budget:  16,
+			want: map[string]string{
+				"a": "Cats purr when happy.",
+				"b": "Dogs bark at strangers.",
+			},
+		},
+		{
+			name: "non-text documents are kept whole",
+			documents: []chat.Document{
+				screenshot,
+				markdown("a", "Cats sleep most of the day."),
+			},
+			queries: []string{"cats"},
+			budget:  1000,
+			want: map[string]string{
+				"image": "",
+				"a":     "Cats sleep most of the day.",
+			},
+		},
+		{
+			name: "no match falls back to leading passages",
+			documents: []chat.Document{
+				markdown("a", "고양이는 잔다.", "고양이는 운다."),
+				markdown("b", "개는 짖는다.", "개는 걷는다."),
+			},
+			queries: []string{"the cats"},
+			budget:  15,
+			want: map[string]string{
+				"a": "고양이는 잔다.",
+				"b": "개는 짖는다.",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			for _, doc := range Select(tt.documents, tt.queries, tt.budget, 10) {
+				got[doc.Source] = text(doc)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectHTML(t *testing.T) {
+	doc := chat.Document{
+		Source:   "a",
+		Format:   chat.DocumentFormatHTML,
+		Contents: []llm.Segment{llm.Text("<p>Cats sleep.</p><p>Dogs bark.</p>")},
+	}
+	got := Select([]chat.Document{doc}, []string{"dogs"}, 1000, 3)
+	if len(got) != 1 || got[0].Format != chat.DocumentFormatText || text(got[0]) != "Dogs bark." {
+		t.Errorf("Select() = %+v, want the text of the matching paragraph", got)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	plan := &queryplan.QueryPlan{SearchQueries: []queryplan.SearchQueries{
+		{Query: "go gc", Description: "how the collector works"},
+	}}
+	want := []string{"user query", "go gc how the collector works"}
+	if got := Queries("user query", plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("Queries() = %q, want %q", got, want)
+	}
+	if got := Queries("user query", nil); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Queries(nil plan) = %q", got)
+	}
+}
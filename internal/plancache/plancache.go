@@ -0,0 +1,214 @@
+package plancache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/lemon-mint/infofluss/internal/langdetect"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+)
+
+const (
+	// DefaultSize is the default number of plans kept in memory.
+	DefaultSize = 1024
+	// DefaultTTL is the default lifetime of a cached plan.
+	DefaultTTL = 24 * time.Hour
+)
+
+type entry struct {
+	Key     string               `json:"key"`
+	Plan    *queryplan.QueryPlan `json:"plan"`
+	Expires time.Time            `json:"expires"`
+	element *list.Element
+}
+
+// Cache is an LRU cache of query plans keyed on the normalized query text and
+// its language. Entries expire after a TTL. If a directory is given, plans
+// are also stored on disk, one JSON file per key, and survive restarts.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	dir     string
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*entry
+}
+
+// New returns a cache holding up to size plans in memory for ttl. If dir is
+// not empty, plans are persisted there.
+func New(size int, ttl time.Duration, dir string) (*Cache, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if dir != "" {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		dir:     dir,
+		lru:     list.New(),
+		entries: make(map[string]*entry),
+	}, nil
+}
+
+// Normalize folds the differences between near-identical queries: case,
+// punctuation and whitespace.
+func Normalize(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// Key returns the cache key of a query.
+func Key(query string) string {
+	return langdetect.Detect(query) + ":" + Normalize(query)
+}
+
+// Get returns a copy of the cached plan for query, if there is one that has
+// not expired.
+func (c *Cache) Get(query string) (*queryplan.QueryPlan, bool) {
+	key := Key(query)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok && c.dir != "" {
+		e, ok = c.load(key)
+		if ok {
+			c.insert(e)
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(e.Expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e.element)
+
+	return clonePlan(e.Plan), true
+}
+
+// Put caches plan for query. Time-sensitive plans and plans asking for a
+// clarification are not cached.
+func (c *Cache) Put(query string, plan *queryplan.QueryPlan) {
+	if plan == nil || plan.TimeSensitive || plan.Clarification != nil {
+		return
+	}
+
+	e := &entry{
+		Key:     Key(query),
+		Plan:    clonePlan(plan),
+		Expires: time.Now().Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[e.Key]; ok {
+		c.lru.Remove(old.element)
+		delete(c.entries, old.Key)
+	}
+	c.insert(e)
+	if c.dir != "" {
+		c.store(e)
+	}
+}
+
+// insert adds e to the memory cache, evicting the least recently used entry
+// if the cache is full. Evicted entries stay on disk.
+func (c *Cache) insert(e *entry) {
+	e.element = c.lru.PushFront(e)
+	c.entries[e.Key] = e
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).Key)
+	}
+}
+
+// remove deletes e from memory and disk.
+func (c *Cache) remove(e *entry) {
+	c.lru.Remove(e.element)
+	delete(c.entries, e.Key)
+	if c.dir != "" {
+		os.Remove(c.path(e.Key))
+	}
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) load(key string) (*entry, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var e entry
+	err = json.Unmarshal(data, &e)
+	if err != nil || e.Key != key || e.Plan == nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// store writes e to disk. Errors are ignored: the disk store is only a
+// second level of the cache.
+func (c *Cache) store(e *entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	f, err := os.CreateTemp(c.dir, ".plan-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if os.Rename(f.Name(), c.path(e.Key)) != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// clonePlan returns a deep copy of plan, as sessions modify their plans.
+func clonePlan(plan *queryplan.QueryPlan) *queryplan.QueryPlan {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return nil
+	}
+	var clone queryplan.QueryPlan
+	if json.Unmarshal(data, &clone) != nil {
+		return nil
+	}
+	return &clone
+}
@@ -0,0 +1,171 @@
+package plancache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+)
+
+func testPlan(query string) *queryplan.QueryPlan {
+	return &queryplan.QueryPlan{
+		Language:      "en",
+		SearchQueries: []queryplan.SearchQueries{{Query: query, DependsOn: []int{}}},
+		Instruction:   "answer",
+	}
+}
+
+func newCache(t *testing.T, size int, dir string) *Cache {
+	t.Helper()
+	c, err := New(size, time.Hour, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"What is Go?", "what is go", true},
+		{"  what   is\tgo ", "What is Go!!", true},
+		{"go generics", "go-generics", true},
+		{"what is go", "what is rust", false},
+		{"서울 날씨", "서울 날씨?", true},
+		{"東京", "东京", false},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.a) == Key(tt.b); got != tt.equal {
+			t.Errorf("Key(%q) == Key(%q) is %v, want %v (%q, %q)", tt.a, tt.b, got, tt.equal, Key(tt.a), Key(tt.b))
+		}
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	c := newCache(t, 0, "")
+
+	if _, ok := c.Get("what is go"); ok {
+		t.Fatal("Get() on an empty cache found a plan")
+	}
+
+	c.Put("What is Go?", testPlan("go"))
+	plan, ok := c.Get("what is go")
+	if !ok || plan.SearchQueries[0].Query != "go" {
+		t.Fatalf("Get() = %+v, %v, want the cached plan", plan, ok)
+	}
+
+	c.Put("what is go", testPlan("golang"))
+	if plan, _ := c.Get("what is go"); plan.SearchQueries[0].Query != "golang" {
+		t.Errorf("Get() after a second Put() = %+v, want the new plan", plan)
+	}
+}
+
+func TestPutSkipsPlans(t *testing.T) {
+	timeSensitive := testPlan("weather")
+	timeSensitive.TimeSensitive = true
+	clarifying := testPlan("jaguar")
+	clarifying.Clarification = &queryplan.Clarification{Question: "Which?", Options: []string{"car", "animal"}}
+
+	tests := []struct {
+		name string
+		plan *queryplan.QueryPlan
+	}{
+		{"nil", nil},
+		{"time sensitive", timeSensitive},
+		{"clarification", clarifying},
+	}
+	for _, tt := range tests {
+		c := newCache(t, 0, "")
+		c.Put("query", tt.plan)
+		if _, ok := c.Get("query"); ok {
+			t.Errorf("%s plan was cached", tt.name)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	c := newCache(t, 0, "")
+	plan := testPlan("go")
+	c.Put("query", plan)
+
+	// Neither the plan put nor the plans returned share memory with the
+	// cached one.
+	plan.SearchQueries[0].Query = "changed by the caller"
+	got, _ := c.Get("query")
+	got.SearchQueries[0].Query = "changed by a session"
+	got.SearchQueries = append(got.SearchQueries, queryplan.SearchQueries{Query: "added"})
+
+	again, _ := c.Get("query")
+	if len(again.SearchQueries) != 1 || again.SearchQueries[0].Query != "go" {
+		t.Errorf("Get() = %+v, want the plan as it was put", again)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := newCache(t, 2, "")
+	c.Put("a", testPlan("a"))
+	c.Put("b", testPlan("b"))
+	c.Get("a") // b is now the least recently used
+	c.Put("c", testPlan("c"))
+
+	for query, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.Get(query); ok != want {
+			t.Errorf("Get(%q) found = %v, want %v", query, ok, want)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	dir := t.TempDir()
+	c := newCache(t, 0, dir)
+	c.Put("query", testPlan("go"))
+
+	c.entries[Key("query")].Expires = time.Now().Add(-time.Second)
+	if _, ok := c.Get("query"); ok {
+		t.Error("Get() returned an expired plan")
+	}
+	if _, err := os.Stat(c.path(Key("query"))); !os.IsNotExist(err) {
+		t.Errorf("expired plan is still on disk: %v", err)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "plans")
+	c := newCache(t, 1, dir)
+	c.Put("a", testPlan("a"))
+	c.Put("b", testPlan("b")) // evicts a from memory
+
+	if _, ok := c.entries[Key("a")]; ok {
+		t.Fatal("a is still in memory")
+	}
+	if plan, ok := c.Get("a"); !ok || plan.SearchQueries[0].Query != "a" {
+		t.Errorf("Get() of an evicted plan = %+v, %v, want it loaded from disk", plan, ok)
+	}
+
+	// Plans survive a restart.
+	restarted := newCache(t, 0, dir)
+	if plan, ok := restarted.Get("b"); !ok || plan.SearchQueries[0].Query != "b" {
+		t.Errorf("Get() after restart = %+v, %v, want the stored plan", plan, ok)
+	}
+
+	// Corrupted and mismatched files are ignored.
+	os.WriteFile(restarted.path(Key("c")), []byte("{not json"), 0o644)
+	if _, ok := restarted.Get("c"); ok {
+		t.Error("Get() returned a plan from a corrupted file")
+	}
+	data, _ := os.ReadFile(restarted.path(Key("b")))
+	os.WriteFile(restarted.path(Key("d")), data, 0o644)
+	if _, ok := restarted.Get("d"); ok {
+		t.Error("Get() returned a plan stored for another key")
+	}
+
+	// No temporary files are left behind.
+	tmp, _ := filepath.Glob(filepath.Join(dir, ".plan-*"))
+	if len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
@@ -0,0 +1,76 @@
+package queryplan
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/lemon-mint/infofluss/internal/langdetect"
+)
+
+// maxKeywords is the maximum number of words of the keyword variant of a
+// fallback query.
+const maxKeywords = 8
+
+// questionWords are dropped from the keyword variant of a fallback query.
+var questionWords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "was": true,
+	"were": true, "do": true, "does": true, "did": true, "can": true, "could": true,
+	"should": true, "would": true, "will": true, "what": true, "which": true,
+	"who": true, "whom": true, "whose": true, "when": true, "where": true,
+	"why": true, "how": true, "i": true, "me": true, "my": true, "you": true,
+	"your": true, "of": true, "to": true, "in": true, "on": true, "for": true,
+	"about": true, "and": true, "or": true, "please": true, "tell": true,
+	"explain": true, "there": true, "it": true, "be": true, "with": true,
+}
+
+// Keywords returns the significant words of query: punctuation and question
+// words are removed and at most maxKeywords words are kept.
+func Keywords(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '.' && r != '+' && r != '#'
+	})
+
+	var keywords []string
+	for _, w := range words {
+		w = strings.Trim(w, "-.")
+		if w == "" || questionWords[strings.ToLower(w)] {
+			continue
+		}
+		keywords = append(keywords, w)
+		if len(keywords) == maxKeywords {
+			break
+		}
+	}
+	return strings.Join(keywords, " ")
+}
+
+// FallbackQueryPlan builds a minimal plan directly from the user query,
+// without calling a model. It is used when the planner fails, so that the
+// user still gets an answer: it searches for the raw query and, when it
+// differs, for its keywords.
+func FallbackQueryPlan(query string) *QueryPlan {
+	query = strings.TrimSpace(query)
+	language := langdetect.Detect(query)
+	if language == "" {
+		language = "en"
+	}
+
+	plan := &QueryPlan{
+		Language: language,
+		SearchQueries: []SearchQueries{{
+			Query:       query,
+			Description: "Information that answers the user query.",
+		}},
+		Instruction: "Answer the user query using the search results, in the language of the query.",
+	}
+
+	keywords := Keywords(query)
+	if keywords != "" && !strings.EqualFold(keywords, query) {
+		plan.SearchQueries = append(plan.SearchQueries, SearchQueries{
+			Query:       keywords,
+			Description: "Background information about the main topics of the user query.",
+		})
+	}
+
+	return plan
+}
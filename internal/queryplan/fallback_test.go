@@ -0,0 +1,96 @@
+package queryplan
+
+import (
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"What is the capital of France?", "capital France"},
+		{"How do I use generics in Go 1.18?", "use generics Go 1.18"},
+		{"C++ vs. C# for game-development...", "C++ vs C# game-development"},
+		{"Tell me about the Eiffel Tower, please!", "Eiffel Tower"},
+		{"서울 날씨는 어때?", "서울 날씨는 어때"},
+		{"one two three four five six seven eight nine ten", "one two three four five six seven eight"},
+		{"What is it?", ""},
+		{"  ?!  ", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Keywords(tt.query); got != tt.want {
+			t.Errorf("Keywords(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFallbackQueryPlan(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		wantLanguage string
+		wantQueries  []string
+	}{
+		{
+			name:         "raw query and keywords",
+			query:        "  What is the capital of France? ",
+			wantLanguage: "en",
+			wantQueries:  []string{"What is the capital of France?", "capital France"},
+		},
+		{
+			name:         "keywords equal to the query",
+			query:        "Eiffel Tower",
+			wantLanguage: "en",
+			wantQueries:  []string{"Eiffel Tower"},
+		},
+		{
+			name:         "only stop words",
+			query:        "what is it",
+			wantLanguage: "en",
+			wantQueries:  []string{"what is it"},
+		},
+		{
+			name:         "detected language",
+			query:        "서울 날씨는 어때?",
+			wantLanguage: "ko",
+			wantQueries:  []string{"서울 날씨는 어때?", "서울 날씨는 어때"},
+		},
+		{
+			name:         "language defaults to English",
+			query:        "1.18",
+			wantLanguage: "en",
+			wantQueries:  []string{"1.18"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := FallbackQueryPlan(tt.query)
+			if plan.Language != tt.wantLanguage {
+				t.Errorf("Language = %q, want %q", plan.Language, tt.wantLanguage)
+			}
+			var queries []string
+			for _, q := range plan.SearchQueries {
+				queries = append(queries, q.Query)
+				if q.Description == "" {
+					t.Errorf("query %q has no description", q.Query)
+				}
+			}
+			if len(queries) != len(tt.wantQueries) {
+				t.Fatalf("queries = %q, want %q", queries, tt.wantQueries)
+			}
+			for i := range queries {
+				if queries[i] != tt.wantQueries[i] {
+					t.Errorf("queries = %q, want %q", queries, tt.wantQueries)
+					break
+				}
+			}
+			if err := plan.Validate(0); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package queryplan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/coord/llmtools"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// HopDecision is the planner's verdict between two hops of a multi-hop
+// search.
+type HopDecision struct {
+	// Sufficient is set when the findings so far answer the user query and
+	// the remaining search queries can be skipped.
+	Sufficient bool   `yaml:"sufficient" json:"sufficient"`
+	Reason     string `yaml:"reason" json:"reason"`
+
+	// SearchQueries are the rewritten next search queries, in the order they
+	// were given to NextHop. Empty when Sufficient is set.
+	SearchQueries []SearchQueries `yaml:"search_queries" json:"search_queries"`
+}
+
+const hopPrompt = `You are planning a sequential web search. Current time is {{CURRENT_TIME}}.
+
+The user asked:
+<user_query>
+{{USER_QUERY}}
+</user_query>
+
+The search queries that have been run so far:
+<done_queries>
+{{DONE_QUERIES}}
+</done_queries>
+
+What was found by them:
+<findings>
+{{FINDINGS}}
+</findings>
+
+The next search queries were written before these findings were known:
+<next_queries>
+{{NEXT_QUERIES}}
+</next_queries>
+
+1. Decide whether the findings are already sufficient to fully answer the user query. Only say so if no information the next queries are meant to find is missing.
+
+2. If they are not sufficient, rewrite every next query using the findings: replace guesses with the names, dates, versions or other facts that were found, and make the query as specific as possible. Keep the same number of queries, in the same order. Keep the search keywords in the same language as the original query.
+
+3. Provide your output in YAML format, structured as follows:
+
+` + "```yaml\n" + `
+sufficient: (true or false)
+reason: "(short explanation of the decision)"
+search_queries: # leave empty if sufficient
+- query: "(rewritten first next query)"
+  description: "(description of information to extract)"
+` + "```"
+
+// NextHop asks the model whether the findings of the queries run so far
+// answer the user query, and if not, to rewrite the queries of plan with the
+// indexes next using those findings. done and next must be distinct indexes
+// of plan.SearchQueries.
+func NextHop(ctx context.Context, m llm.Model, query string, plan *QueryPlan, done, next []int, findings string) (*HopDecision, error) {
+	seen := make(map[int]bool)
+	for _, i := range append(append([]int{}, done...), next...) {
+		if i < 0 || i >= len(plan.SearchQueries) {
+			return nil, fmt.Errorf("invalid hop: search query %d does not exist", i)
+		}
+		if seen[i] {
+			return nil, fmt.Errorf("invalid hop: search query %d is given twice", i)
+		}
+		seen[i] = true
+	}
+
+	var doneQueries, nextQueries strings.Builder
+	for _, i := range done {
+		fmt.Fprintf(&doneQueries, "- query: %q\n  description: %q\n", plan.SearchQueries[i].Query, plan.SearchQueries[i].Description)
+	}
+	for _, i := range next {
+		fmt.Fprintf(&nextQueries, "- query: %q\n  description: %q\n", plan.SearchQueries[i].Query, plan.SearchQueries[i].Description)
+	}
+	if strings.TrimSpace(findings) == "" {
+		findings = "(nothing was found)"
+	}
+
+	prompt := strings.ReplaceAll(hopPrompt, "{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z))
+	prompt = strings.ReplaceAll(prompt, "{{USER_QUERY}}", query)
+	prompt = strings.ReplaceAll(prompt, "{{DONE_QUERIES}}", strings.TrimSpace(doneQueries.String()))
+	prompt = strings.ReplaceAll(prompt, "{{NEXT_QUERIES}}", strings.TrimSpace(nextQueries.String()))
+	prompt = strings.ReplaceAll(prompt, "{{FINDINGS}}", findings)
+
+	stream := m.GenerateStream(ctx, &llm.ChatContext{}, llm.TextContent(llm.RoleUser, prompt))
+	err := stream.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimSpace(llmtools.TextFromContents(stream.Content))
+	for _, fence := range []string{"```yaml\n", "```yml\n", "```\n"} {
+		if _, after, ok := strings.Cut(text, fence); ok {
+			text, _, _ = strings.Cut(after, "```")
+			break
+		}
+	}
+
+	var decision HopDecision
+	err = yaml.Unmarshal([]byte(text), &decision)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hop decision: %w", err)
+	}
+	if decision.Sufficient {
+		decision.SearchQueries = nil
+		return &decision, nil
+	}
+
+	if len(decision.SearchQueries) != len(next) {
+		return nil, fmt.Errorf("invalid hop decision: expected %d rewritten queries, got %d", len(next), len(decision.SearchQueries))
+	}
+	for i := range decision.SearchQueries {
+		decision.SearchQueries[i].Query = strings.TrimSpace(decision.SearchQueries[i].Query)
+		if decision.SearchQueries[i].Query == "" {
+			return nil, errors.New("invalid hop decision: empty rewritten query")
+		}
+		if decision.SearchQueries[i].Description == "" {
+			decision.SearchQueries[i].Description = plan.SearchQueries[next[i]].Description
+		}
+		decision.SearchQueries[i].DependsOn = plan.SearchQueries[next[i]].DependsOn
+	}
+
+	return &decision, nil
+}
@@ -0,0 +1,135 @@
+package queryplan
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+)
+
+func TestNextHop(t *testing.T) {
+	plan := &QueryPlan{
+		Language: "en",
+		SearchQueries: []SearchQueries{
+			{Query: "who directed Inception", Description: "director"},
+			{Query: "director's latest film", Description: "latest film", DependsOn: []int{0}},
+			{Query: "director's awards", Description: "awards", DependsOn: []int{0}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		done      []int
+		next      []int
+		reply     string
+		want      *HopDecision
+		wantErr   string
+		noRequest bool
+	}{
+		{
+			name:  "rewritten queries",
+			done:  []int{0},
+			next:  []int{1, 2},
+			reply: "```yaml\nsufficient: false\nreason: need films\nsearch_queries:\n  - query: ' Christopher Nolan latest film '\n    description: newest film\n  - query: Christopher Nolan awards\n```",
+			want: &HopDecision{Reason: "need films", SearchQueries: []SearchQueries{
+				{Query: "Christopher Nolan latest film", Description: "newest film", DependsOn: []int{0}},
+				{Query: "Christopher Nolan awards", Description: "awards", DependsOn: []int{0}},
+			}},
+		},
+		{
+			name:  "sufficient drops the queries",
+			done:  []int{0},
+			next:  []int{1},
+			reply: "sufficient: true\nreason: answered\nsearch_queries:\n  - query: more\n",
+			want:  &HopDecision{Sufficient: true, Reason: "answered"},
+		},
+		{
+			name:    "wrong number of queries",
+			done:    []int{0},
+			next:    []int{1, 2},
+			reply:   "sufficient: false\nsearch_queries:\n  - query: a\n",
+			wantErr: "expected 2 rewritten queries, got 1",
+		},
+		{
+			name:    "empty rewritten query",
+			done:    []int{0},
+			next:    []int{1},
+			reply:   "sufficient: false\nsearch_queries:\n  - query: ' '\n",
+			wantErr: "empty rewritten query",
+		},
+		{
+			name:    "malformed YAML",
+			done:    []int{0},
+			next:    []int{1},
+			reply:   "sufficient: [",
+			wantErr: "invalid hop decision",
+		},
+		{
+			name:      "out of range next query",
+			done:      []int{0},
+			next:      []int{3},
+			wantErr:   "search query 3 does not exist",
+			noRequest: true,
+		},
+		{
+			name:      "negative done query",
+			done:      []int{-1},
+			next:      []int{1},
+			wantErr:   "search query -1 does not exist",
+			noRequest: true,
+		},
+		{
+			name:      "duplicate next query",
+			done:      []int{0},
+			next:      []int{1, 1},
+			wantErr:   "search query 1 is given twice",
+			noRequest: true,
+		},
+		{
+			name:      "query both done and next",
+			done:      []int{0},
+			next:      []int{0},
+			wantErr:   "search query 0 is given twice",
+			noRequest: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &scriptedModel{replies: []*llm.Content{textReply(tt.reply)}}
+			got, err := NextHop(context.Background(), m, "Inception director", plan, tt.done, tt.next, "Christopher Nolan directed it.")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NextHop() = %+v, %v, want error %q", got, err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("NextHop() error = %v", err)
+			} else if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NextHop() = %+v, want %+v", got, tt.want)
+			}
+			if tt.noRequest && len(m.inputs) > 0 {
+				t.Error("NextHop() asked the model for an invalid hop")
+			}
+		})
+	}
+}
+
+func TestNextHopPrompt(t *testing.T) {
+	plan := &QueryPlan{SearchQueries: []SearchQueries{{Query: "a", Description: "x"}, {Query: "b", Description: "y"}}}
+	m := &scriptedModel{replies: []*llm.Content{textReply("sufficient: true\n")}}
+	if _, err := NextHop(context.Background(), m, "user query", plan, []int{0}, []int{1}, "  "); err != nil {
+		t.Fatal(err)
+	}
+	prompt := string(m.inputs[0].Parts[0].(llm.Text))
+	for _, want := range []string{
+		"<done_queries>\n- query: \"a\"\n  description: \"x\"\n</done_queries>",
+		"<next_queries>\n- query: \"b\"\n  description: \"y\"\n</next_queries>",
+		"(nothing was found)",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("NextHop() prompt does not contain %q", want)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package queryplan
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -15,11 +18,31 @@ type QueryPlan struct {
 	Language      string          `yaml:"language" json:"language"`
 	SearchQueries []SearchQueries `yaml:"search_queries" json:"search_queries"`
 	Instruction   string          `yaml:"instruction" json:"instruction"`
+
+	// TimeSensitive is set when the answer depends on the current time
+	// (weather, news, prices, ...), so the plan must not be reused later.
+	TimeSensitive bool `yaml:"time_sensitive,omitempty" json:"time_sensitive,omitempty"`
+
+	// Clarification is set when the user query is ambiguous. The search
+	// queries then cover the most likely meaning.
+	Clarification *Clarification `yaml:"clarification,omitempty" json:"clarification,omitempty"`
+}
+
+// Clarification is a question to ask the user about an ambiguous query.
+type Clarification struct {
+	Question string `yaml:"question" json:"question"`
+	// Options are the possible meanings of the query, each written as a
+	// complete query that can replace the original one.
+	Options []string `yaml:"options" json:"options"`
 }
 
 type SearchQueries struct {
 	Query       string `yaml:"query" json:"query"`
 	Description string `yaml:"description" json:"description"`
+
+	// DependsOn lists the indexes of earlier search queries whose findings
+	// are needed to write this query, e.g. to look up a name found by them.
+	DependsOn []int `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
 }
 
 const prompt = `You are a search query generator. Your role is to analyze user queries and generate specific search queries that can be used in search engines. Follow these instructions carefully:
@@ -38,13 +61,10 @@ const prompt = `You are a search query generator. Your role is to analyze user q
 4. Generate search queries following these rules:
    - Focus on generating search queries in a sequential search process.
    - For each search query, include a description of the information to be extracted from the search.
+   - If a search query can only be written once the results of earlier search queries are known (e.g. it needs a name, date or version found by them), list the zero-based indexes of those earlier queries in depends_on and write the query with your best guess. It will be rewritten with the findings before it is run. Leave depends_on out for independent queries.
+   - Generate at most {{MAX_QUERIES}} search queries.{{CLARIFICATION_RULE}}
 
-5. Provide your output in YAML format, structured as follows:
-   - language: (two-letter language code)
-   - search_queries: (list of search queries)
-     - query: (the search query)
-     - description: (description of information to extract)
-   - instruction: (specify the user's intent and the action to be taken after the search for further processing)
+5. {{OUTPUT_FORMAT}}
 
 6. Generate search keywords only in English, but if the input question language is not English and related to local information like opening hours, local event, local places..., generate search keywords in both the detected language and English.
 
@@ -54,47 +74,357 @@ const prompt = `You are a search query generator. Your role is to analyze user q
 (Your step-by-step reasoning process goes here)
 </reasoning>
 
-` + "```yaml\n" + `
+{{OUTPUT_EXAMPLE}}
+
+----
+Start your response with <reasoning>`
+
+const yamlOutputFormat = `Provide your output in YAML format, structured as follows:
+   - language: (two-letter language code)
+   - search_queries: (list of search queries)
+     - query: (the search query)
+     - description: (description of information to extract)
+     - depends_on: (optional, indexes of earlier search queries this query depends on)
+   - instruction: (specify the user's intent and the action to be taken after the search for further processing)
+   - time_sensitive: (true if the answer depends on the current date or time, e.g. weather, news, prices, schedules or "latest" versions; otherwise false)`
+
+const yamlOutputExample = "```yaml\n" + `
 language: (language code)
 search_queries:
 - query: "(first search query)"
   description: "(description of information to extract)"
 - query: "(second search query)"
   description: "(description of information to extract)"
+  depends_on: [0] # only if the query needs the findings of the first query
 instruction: |-
   (Instruction for further processing)
-` + "```" + `
+time_sensitive: false
+` + "```"
 
-----
-Start your response with <reasoning>`
+const toolOutputFormat = `Provide your output by calling the ` + "`" + submitToolName + "`" + ` function exactly once, with:
+   - language: (two-letter language code)
+   - search_queries: (list of search queries)
+     - query: (the search query)
+     - description: (description of information to extract)
+     - depends_on: (optional, indexes of earlier search queries this query depends on)
+   - instruction: (specify the user's intent and the action to be taken after the search for further processing)
+   - time_sensitive: (true if the answer depends on the current date or time, e.g. weather, news, prices, schedules or "latest" versions; otherwise false)`
+
+const toolOutputExample = `(Call the ` + "`" + submitToolName + "`" + ` function with the query plan)`
+
+const clarificationRule = `
+   - If the user query is ambiguous, i.e. it has several distinct meanings that would need entirely different searches (e.g. "mercury" the planet, the element or the Roman god), add a clarification field with a short question to ask the user and 2 to 5 options. Write every option as a complete, unambiguous rewrite of the user query, in the language of the user query. Still generate the search queries for the most likely meaning. Do not ask for clarification when the meaning is clear from the query.`
+
+const repairPrompt = `Your previous query plan could not be used: {{ERROR}}
+
+Please fix the problem and provide the complete query plan again, following the original output format.`
+
+const submitToolName = "submit_query_plan"
+
+var planSchema = &llm.Schema{
+	Type: llm.OpenAPITypeObject,
+	Properties: map[string]*llm.Schema{
+		"language": {
+			Type:        llm.OpenAPITypeString,
+			Description: "Two-letter ISO 639-1 code of the language of the user query.",
+		},
+		"search_queries": {
+			Type:        llm.OpenAPITypeArray,
+			Description: "Search queries to run, in order.",
+			Items: &llm.Schema{
+				Type: llm.OpenAPITypeObject,
+				Properties: map[string]*llm.Schema{
+					"query": {
+						Type:        llm.OpenAPITypeString,
+						Description: "The search query.",
+					},
+					"description": {
+						Type:        llm.OpenAPITypeString,
+						Description: "Description of the information to extract from the search.",
+					},
+					"depends_on": {
+						Type:        llm.OpenAPITypeArray,
+						Description: "Zero-based indexes of earlier search queries whose findings are needed to write this query.",
+						Items:       &llm.Schema{Type: llm.OpenAPITypeInteger},
+					},
+				},
+				Required: []string{"query", "description"},
+			},
+		},
+		"instruction": {
+			Type:        llm.OpenAPITypeString,
+			Description: "The user's intent and the action to be taken after the search for further processing.",
+		},
+		"time_sensitive": {
+			Type:        llm.OpenAPITypeBoolean,
+			Description: "Whether the answer depends on the current date or time (weather, news, prices, schedules, latest versions).",
+		},
+		"clarification": {
+			Type:        llm.OpenAPITypeObject,
+			Description: "Only if the user query is ambiguous: a question to ask the user and the possible meanings.",
+			Properties: map[string]*llm.Schema{
+				"question": {
+					Type:        llm.OpenAPITypeString,
+					Description: "The question to ask the user.",
+				},
+				"options": {
+					Type:        llm.OpenAPITypeArray,
+					Description: "2 to 5 complete, unambiguous rewrites of the user query.",
+					Items:       &llm.Schema{Type: llm.OpenAPITypeString},
+				},
+			},
+			Required: []string{"question", "options"},
+		},
+	},
+	Required: []string{"language", "search_queries", "instruction"},
+}
+
+var submitTool = &llm.FunctionDeclaration{
+	Name:        submitToolName,
+	Description: "Submit the query plan for the user query.",
+	Schema:      planSchema,
+}
+
+// DefaultMaxQueries is the maximum number of search queries of a plan when
+// Options.MaxQueries is not set.
+const DefaultMaxQueries = 8
+
+type Options struct {
+	// StructuredOutput makes the model submit the plan through a function
+	// call constrained by the QueryPlan schema instead of a YAML block. Only
+	// enable it for providers with native function calling.
+	StructuredOutput bool
+
+	// MaxQueries bounds the number of search queries (default: DefaultMaxQueries).
+	MaxQueries int
+
+	// AllowClarification lets the model ask which meaning of an ambiguous
+	// query the user has in mind (see QueryPlan.Clarification).
+	AllowClarification bool
+}
+
+// maxClarificationOptions is the maximum number of options of a clarification.
+const maxClarificationOptions = 5
 
 var ErrFailedToGenerateQueryPlan = errors.New("Failed to generate query plan")
 
-func GenerateQueryPlan(ctx context.Context, m llm.Model, query string) (*QueryPlan, error) {
+var languageCode = regexp.MustCompile(`^[a-z]{2}$`)
+
+// Validate checks that the plan can be executed: it must have a valid
+// two-letter language code, and between one and maxQueries non-empty search
+// queries that only depend on earlier queries. The language code is
+// normalized to lower case.
+func (p *QueryPlan) Validate(maxQueries int) error {
+	if maxQueries <= 0 {
+		maxQueries = DefaultMaxQueries
+	}
+
+	lang, _, _ := strings.Cut(strings.TrimSpace(p.Language), "-")
+	lang = strings.ToLower(lang)
+	if !languageCode.MatchString(lang) {
+		return fmt.Errorf("invalid language code %q, expected a two-letter code", p.Language)
+	}
+	p.Language = lang
+
+	if len(p.SearchQueries) == 0 {
+		return errors.New("search_queries is empty")
+	}
+	if len(p.SearchQueries) > maxQueries {
+		return fmt.Errorf("too many search queries (%d), at most %d are allowed", len(p.SearchQueries), maxQueries)
+	}
+	if p.Clarification != nil {
+		var options []string
+		for _, option := range p.Clarification.Options {
+			if option = strings.TrimSpace(option); option != "" {
+				options = append(options, option)
+			}
+		}
+		if len(options) > maxClarificationOptions {
+			options = options[:maxClarificationOptions]
+		}
+		p.Clarification.Options = options
+		// A single option is no question.
+		if len(options) < 2 {
+			p.Clarification = nil
+		}
+	}
+
+	for i := range p.SearchQueries {
+		p.SearchQueries[i].Query = strings.TrimSpace(p.SearchQueries[i].Query)
+		if p.SearchQueries[i].Query == "" {
+			return fmt.Errorf("search_queries[%d].query is empty", i)
+		}
+		for _, dep := range p.SearchQueries[i].DependsOn {
+			if dep < 0 || dep >= i {
+				return fmt.Errorf("search_queries[%d].depends_on refers to %d, only earlier queries (0 to %d) are allowed", i, dep, i-1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// HasDependencies reports whether any search query of the plan depends on
+// the findings of another one.
+func (p *QueryPlan) HasDependencies() bool {
+	for _, q := range p.SearchQueries {
+		if len(q.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseText extracts the plan from a YAML (or JSON) block of the reply.
+func parseText(text string) (*QueryPlan, error) {
+	text = strings.TrimSpace(text)
+	for _, fence := range []string{"```yaml\n", "```yml\n", "```json\n", "```\n"} {
+		if _, after, ok := strings.Cut(text, fence); ok {
+			text, _, _ = strings.Cut(after, "```")
+			break
+		}
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, errors.New("no query plan found in the response")
+	}
+
+	var queryPlan QueryPlan
+	err := yaml.Unmarshal([]byte(text), &queryPlan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query plan: %w", err)
+	}
+	return &queryPlan, nil
+}
+
+// parseCall extracts the plan from the arguments of a submit_query_plan call.
+func parseCall(call *llm.FunctionCall) (*QueryPlan, error) {
+	data, err := json.Marshal(call.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	var queryPlan QueryPlan
+	err = json.Unmarshal(data, &queryPlan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query plan: %w", err)
+	}
+	return &queryPlan, nil
+}
+
+// parseReply returns the plan found in the reply of the model, along with
+// the function call it was submitted with, if any.
+func parseReply(reply *llm.Content, maxQueries int) (*QueryPlan, *llm.FunctionCall, error) {
+	var plan *QueryPlan
+	var call *llm.FunctionCall
+	var err error
+
+	if reply != nil {
+		for _, part := range reply.Parts {
+			if c, ok := part.(*llm.FunctionCall); ok && c.Name == submitToolName {
+				call = c
+				break
+			}
+		}
+	}
+
+	if call != nil {
+		plan, err = parseCall(call)
+	} else {
+		plan, err = parseText(llmtools.TextFromContents(reply))
+	}
+	if err != nil {
+		return nil, call, err
+	}
+
+	err = plan.Validate(maxQueries)
+	if err != nil {
+		return nil, call, err
+	}
+
+	return plan, call, nil
+}
+
+// GenerateQueryPlan asks the model for a query plan for the user query.
+//
+// The reply is validated (see QueryPlan.Validate); if it cannot be parsed or
+// is invalid, the model is told what went wrong and given one chance to
+// repair its plan before ErrFailedToGenerateQueryPlan is returned.
+func GenerateQueryPlan(ctx context.Context, m llm.Model, query string, opts *Options) (*QueryPlan, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	maxQueries := opts.MaxQueries
+	if maxQueries <= 0 {
+		maxQueries = DefaultMaxQueries
+	}
+
+	chat := &llm.ChatContext{}
+	outputFormat, outputExample := yamlOutputFormat, yamlOutputExample
+	if opts.StructuredOutput {
+		chat.Tools = []*llm.FunctionDeclaration{submitTool}
+		outputFormat, outputExample = toolOutputFormat, toolOutputExample
+	}
+
 	prompt := strings.ReplaceAll(prompt, "{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z))
+	prompt = strings.ReplaceAll(prompt, "{{MAX_QUERIES}}", fmt.Sprint(maxQueries))
+	if opts.AllowClarification {
+		prompt = strings.ReplaceAll(prompt, "{{CLARIFICATION_RULE}}", clarificationRule)
+	} else {
+		prompt = strings.ReplaceAll(prompt, "{{CLARIFICATION_RULE}}", "")
+	}
+	prompt = strings.ReplaceAll(prompt, "{{OUTPUT_FORMAT}}", outputFormat)
+	prompt = strings.ReplaceAll(prompt, "{{OUTPUT_EXAMPLE}}", outputExample)
 	prompt = strings.ReplaceAll(prompt, "{{USER_QUERY}}", query)
+	input := llm.TextContent(llm.RoleUser, prompt)
 
-	stream := m.GenerateStream(ctx, &llm.ChatContext{}, llm.TextContent(llm.RoleUser, prompt))
+	stream := m.GenerateStream(ctx, chat, input)
 	err := stream.Wait()
 	if err != nil {
 		return nil, err
 	}
 
-	text := llmtools.TextFromContents(stream.Content)
+	plan, call, err := parseReply(stream.Content, maxQueries)
+	if err == nil {
+		if !opts.AllowClarification {
+			plan.Clarification = nil
+		}
+		return plan, nil
+	}
+	if stream.Content == nil {
+		return nil, fmt.Errorf("%w: %w", ErrFailedToGenerateQueryPlan, err)
+	}
 
-	text = strings.TrimSpace(text)
-	_, text, ok := strings.Cut(text, "```yaml\n")
-	if !ok {
-		return nil, ErrFailedToGenerateQueryPlan
+	// Repair round-trip: show the model its own reply and the problem.
+	chat.Contents = append(chat.Contents, input, stream.Content)
+	repair := strings.ReplaceAll(repairPrompt, "{{ERROR}}", err.Error())
+	if call != nil {
+		input = &llm.Content{
+			Role: llm.RoleFunc,
+			Parts: []llm.Segment{&llm.FunctionResponse{
+				Name:    call.Name,
+				ID:      call.ID,
+				Content: map[string]interface{}{"error": repair},
+				IsError: true,
+			}},
+		}
+	} else {
+		input = llm.TextContent(llm.RoleUser, repair)
 	}
-	text = strings.TrimSuffix(text, "```")
-	text = strings.TrimSpace(text)
 
-	var queryPlan QueryPlan
-	err = yaml.Unmarshal([]byte(text), &queryPlan)
+	stream = m.GenerateStream(ctx, chat, input)
+	err = stream.Wait()
 	if err != nil {
 		return nil, err
 	}
 
-	return &queryPlan, nil
+	plan, _, err = parseReply(stream.Content, maxQueries)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrFailedToGenerateQueryPlan, err)
+	}
+	if !opts.AllowClarification {
+		plan.Clarification = nil
+	}
+
+	return plan, nil
 }
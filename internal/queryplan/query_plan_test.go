@@ -0,0 +1,333 @@
+package queryplan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+)
+
+// scriptedModel replies to every request with the next of its replies and
+// records the inputs it was given.
+type scriptedModel struct {
+	replies []*llm.Content
+	inputs  []*llm.Content
+}
+
+func (m *scriptedModel) GenerateStream(ctx context.Context, chat *llm.ChatContext, input *llm.Content) *llm.StreamContent {
+	m.inputs = append(m.inputs, input)
+	stream := make(chan llm.Segment)
+	close(stream)
+	if len(m.replies) == 0 {
+		return &llm.StreamContent{Err: errors.New("no more replies"), Stream: stream}
+	}
+	reply := m.replies[0]
+	m.replies = m.replies[1:]
+	return &llm.StreamContent{Content: reply, Stream: stream}
+}
+
+func (m *scriptedModel) Close() error { return nil }
+
+func (m *scriptedModel) Name() string { return "scripted" }
+
+func textReply(text string) *llm.Content {
+	return llm.TextContent(llm.RoleModel, text)
+}
+
+func callReply(args map[string]interface{}) *llm.Content {
+	return &llm.Content{
+		Role:  llm.RoleModel,
+		Parts: []llm.Segment{&llm.FunctionCall{Name: submitToolName, ID: "call-1", Args: args}},
+	}
+}
+
+const validYAML = "```yaml\nlanguage: en\nsearch_queries:\n  - query: go generics\n    description: what generics are\ninstruction: explain\n```"
+
+func TestParseText(t *testing.T) {
+	want := &QueryPlan{
+		Language:      "en",
+		SearchQueries: []SearchQueries{{Query: "go generics", Description: "what generics are"}},
+		Instruction:   "explain",
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    *QueryPlan
+		wantErr bool
+	}{
+		{name: "YAML block", text: "Here is the plan:\n" + validYAML, want: want},
+		{name: "unfenced YAML", text: strings.Trim(strings.TrimPrefix(validYAML, "```yaml"), "`\n"), want: want},
+		{
+			name: "JSON block",
+			text: "```json\n{\"language\": \"en\", \"search_queries\": [{\"query\": \"go generics\", \"description\": \"what generics are\"}], \"instruction\": \"explain\"}\n```",
+			want: want,
+		},
+		{
+			name: "dependencies",
+			text: "language: en\nsearch_queries:\n  - query: a\n    description: x\n  - query: b\n    description: y\n    depends_on: [0]\n",
+			want: &QueryPlan{
+				Language: "en",
+				SearchQueries: []SearchQueries{
+					{Query: "a", Description: "x"},
+					{Query: "b", Description: "y", DependsOn: []int{0}},
+				},
+			},
+		},
+		{name: "empty", text: "```yaml\n```", wantErr: true},
+		{name: "invalid YAML", text: "language: [en", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseText(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseText() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	queries := func(n int) []SearchQueries {
+		q := make([]SearchQueries, n)
+		for i := range q {
+			q[i] = SearchQueries{Query: "q"}
+		}
+		return q
+	}
+
+	tests := []struct {
+		name    string
+		plan    QueryPlan
+		want    QueryPlan
+		wantErr string
+	}{
+		{
+			name: "language is normalized",
+			plan: QueryPlan{Language: " EN-us ", SearchQueries: []SearchQueries{{Query: " go "}}},
+			want: QueryPlan{Language: "en", SearchQueries: []SearchQueries{{Query: "go"}}},
+		},
+		{
+			name:    "invalid language",
+			plan:    QueryPlan{Language: "english", SearchQueries: queries(1)},
+			wantErr: "invalid language code",
+		},
+		{
+			name:    "no queries",
+			plan:    QueryPlan{Language: "en"},
+			wantErr: "search_queries is empty",
+		},
+		{
+			name:    "too many queries",
+			plan:    QueryPlan{Language: "en", SearchQueries: queries(DefaultMaxQueries + 1)},
+			wantErr: "too many search queries",
+		},
+		{
+			name:    "empty query",
+			plan:    QueryPlan{Language: "en", SearchQueries: []SearchQueries{{Query: "a"}, {Query: "  "}}},
+			wantErr: "search_queries[1].query is empty",
+		},
+		{
+			name:    "dependency on a later query",
+			plan:    QueryPlan{Language: "en", SearchQueries: []SearchQueries{{Query: "a", DependsOn: []int{1}}, {Query: "b"}}},
+			wantErr: "search_queries[0].depends_on refers to 1",
+		},
+		{
+			name:    "dependency on itself",
+			plan:    QueryPlan{Language: "en", SearchQueries: []SearchQueries{{Query: "a"}, {Query: "b", DependsOn: []int{1}}}},
+			wantErr: "search_queries[1].depends_on refers to 1",
+		},
+		{
+			name: "clarification options are trimmed and capped",
+			plan: QueryPlan{Language: "en", SearchQueries: queries(1), Clarification: &Clarification{
+				Question: "Which one?",
+				Options:  []string{" a ", "", "b", "c", "d", "e", "f"},
+			}},
+			want: QueryPlan{Language: "en", SearchQueries: queries(1), Clarification: &Clarification{
+				Question: "Which one?",
+				Options:  []string{"a", "b", "c", "d", "e"},
+			}},
+		},
+		{
+			name: "clarification with a single option is dropped",
+			plan: QueryPlan{Language: "en", SearchQueries: queries(1), Clarification: &Clarification{
+				Question: "Which one?",
+				Options:  []string{"a", " "},
+			}},
+			want: QueryPlan{Language: "en", SearchQueries: queries(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := tt.plan
+			err := plan.Validate(0)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if !reflect.DeepEqual(plan, tt.want) {
+				t.Errorf("Validate() plan = %+v, want %+v", plan, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	args := map[string]interface{}{
+		"language":       "ko",
+		"search_queries": []interface{}{map[string]interface{}{"query": "서울 날씨", "description": "weather"}},
+		"instruction":    "answer",
+		"time_sensitive": true,
+	}
+
+	tests := []struct {
+		name     string
+		reply    *llm.Content
+		wantPlan bool
+		wantCall bool
+	}{
+		{name: "function call", reply: callReply(args), wantPlan: true, wantCall: true},
+		{name: "text", reply: textReply(validYAML), wantPlan: true},
+		{name: "invalid function call", reply: callReply(map[string]interface{}{"language": "ko"}), wantCall: true},
+		{name: "invalid text", reply: textReply("I cannot help with that.")},
+		{name: "no reply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, call, err := parseReply(tt.reply, 0)
+			if (plan != nil) != tt.wantPlan || (err == nil) != tt.wantPlan {
+				t.Errorf("parseReply() plan = %+v, error = %v, want plan %v", plan, err, tt.wantPlan)
+			}
+			if (call != nil) != tt.wantCall {
+				t.Errorf("parseReply() call = %+v, want call %v", call, tt.wantCall)
+			}
+		})
+	}
+
+	plan, _, _ := parseReply(callReply(args), 0)
+	want := &QueryPlan{
+		Language:      "ko",
+		SearchQueries: []SearchQueries{{Query: "서울 날씨", Description: "weather"}},
+		Instruction:   "answer",
+		TimeSensitive: true,
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("parseReply() = %+v, want %+v", plan, want)
+	}
+}
+
+func TestGenerateQueryPlan(t *testing.T) {
+	invalidArgs := map[string]interface{}{"language": "english", "search_queries": []interface{}{}}
+	validArgs := map[string]interface{}{
+		"language":       "en",
+		"search_queries": []interface{}{map[string]interface{}{"query": "go generics", "description": "x"}},
+		"instruction":    "explain",
+	}
+	clarifying := "```yaml\nlanguage: en\nsearch_queries:\n  - query: jaguar\n    description: x\ninstruction: y\nclarification:\n  question: Which jaguar?\n  options: [jaguar car, jaguar animal]\n```"
+
+	tests := []struct {
+		name          string
+		opts          *Options
+		replies       []*llm.Content
+		wantErr       error
+		wantRequests  int
+		wantClarified bool
+	}{
+		{
+			name:         "valid plan",
+			replies:      []*llm.Content{textReply(validYAML)},
+			wantRequests: 1,
+		},
+		{
+			name:         "text plan repaired",
+			replies:      []*llm.Content{textReply("language: english\nsearch_queries: []"), textReply(validYAML)},
+			wantRequests: 2,
+		},
+		{
+			name:         "function call repaired",
+			opts:         &Options{StructuredOutput: true},
+			replies:      []*llm.Content{callReply(invalidArgs), callReply(validArgs)},
+			wantRequests: 2,
+		},
+		{
+			name:         "repair fails",
+			replies:      []*llm.Content{textReply("no plan"), textReply("still no plan")},
+			wantErr:      ErrFailedToGenerateQueryPlan,
+			wantRequests: 2,
+		},
+		{
+			name:         "clarification dropped when not allowed",
+			replies:      []*llm.Content{textReply(clarifying)},
+			wantRequests: 1,
+		},
+		{
+			name:          "clarification kept when allowed",
+			opts:          &Options{AllowClarification: true},
+			replies:       []*llm.Content{textReply(clarifying)},
+			wantRequests:  1,
+			wantClarified: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &scriptedModel{replies: tt.replies}
+			plan, err := GenerateQueryPlan(context.Background(), m, "user query", tt.opts)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("GenerateQueryPlan() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(m.inputs) != tt.wantRequests {
+				t.Errorf("GenerateQueryPlan() made %d requests, want %d", len(m.inputs), tt.wantRequests)
+			}
+			if err == nil && (plan.Clarification != nil) != tt.wantClarified {
+				t.Errorf("GenerateQueryPlan() clarification = %+v, want %v", plan.Clarification, tt.wantClarified)
+			}
+		})
+	}
+}
+
+func TestGenerateQueryPlanRepairInput(t *testing.T) {
+	// A text reply is repaired with a user message quoting the error.
+	m := &scriptedModel{replies: []*llm.Content{textReply("language: english\nsearch_queries: [{query: a}]"), textReply(validYAML)}}
+	if _, err := GenerateQueryPlan(context.Background(), m, "q", nil); err != nil {
+		t.Fatal(err)
+	}
+	repair := m.inputs[1]
+	if repair.Role != llm.RoleUser || len(repair.Parts) != 1 {
+		t.Fatalf("repair input = %+v, want a user message", repair)
+	}
+	if text, _ := repair.Parts[0].(llm.Text); !strings.Contains(string(text), `invalid language code "english"`) {
+		t.Errorf("repair input = %q, want the validation error", text)
+	}
+
+	// A function call is repaired with an error response to the call.
+	m = &scriptedModel{replies: []*llm.Content{
+		callReply(map[string]interface{}{"language": "en"}),
+		callReply(map[string]interface{}{"language": "en", "search_queries": []interface{}{map[string]interface{}{"query": "a", "description": "b"}}}),
+	}}
+	if _, err := GenerateQueryPlan(context.Background(), m, "q", &Options{StructuredOutput: true}); err != nil {
+		t.Fatal(err)
+	}
+	repair = m.inputs[1]
+	response, ok := repair.Parts[0].(*llm.FunctionResponse)
+	if repair.Role != llm.RoleFunc || !ok {
+		t.Fatalf("repair input = %+v, want a function response", repair)
+	}
+	if response.Name != submitToolName || response.ID != "call-1" || !response.IsError {
+		t.Errorf("repair response = %+v, want an error response to call-1", response)
+	}
+}
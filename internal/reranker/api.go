@@ -0,0 +1,116 @@
+package reranker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// API is a Reranker backed by an HTTP rerank service with the request and
+// response format of the Cohere and Jina rerank APIs (also served by e.g.
+// Text Embeddings Inference and vLLM): it POSTs the query and the documents
+// to {BaseURL}/rerank and gets back scored indexes.
+type API struct {
+	// Client is the HTTP client used for requests (default: http.DefaultClient).
+	Client  *http.Client
+	BaseURL string // e.g. https://api.jina.ai/v1
+	APIKey  string // sent as a bearer token if set
+	Model   string
+}
+
+type apiRequest struct {
+	Model           string   `json:"model,omitempty"`
+	Query           string   `json:"query"`
+	Documents       []string `json:"documents"`
+	TopN            int      `json:"top_n"`
+	ReturnDocuments bool     `json:"return_documents"`
+}
+
+type apiResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+func (r *API) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	if len(candidates) == 0 {
+		return nil, ErrRerankFailed
+	}
+	limit := opts.results()
+	if limit > len(candidates) {
+		limit = len(candidates)
+	}
+
+	documents := make([]string, len(candidates))
+	for i := range candidates {
+		documents[i] = candidates[i].Text()
+	}
+	body, err := json.Marshal(apiRequest{
+		Model:     r.Model,
+		Query:     query,
+		Documents: documents,
+		TopN:      limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.BaseURL, "/")+"/rerank", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if r.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.APIKey)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%w: status code error: %s: %s", ErrRerankFailed, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result apiResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRerankFailed, err)
+	}
+
+	// Services return the results sorted by score, but do not rely on it.
+	sort.SliceStable(result.Results, func(a, b int) bool {
+		return result.Results[a].RelevanceScore > result.Results[b].RelevanceScore
+	})
+
+	var order []Ranked
+	seen := make(map[int]bool)
+	for _, res := range result.Results {
+		if res.Index < 0 || res.Index >= len(candidates) || seen[res.Index] {
+			continue
+		}
+		seen[res.Index] = true
+		order = append(order, Ranked{Index: res.Index, Score: res.RelevanceScore, Scored: true})
+	}
+	if len(order) == 0 {
+		return nil, ErrRerankFailed
+	}
+	if len(order) > limit {
+		order = order[:limit]
+	}
+	return order, nil
+}
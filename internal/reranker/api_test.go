@@ -0,0 +1,135 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var apiCandidates = []Candidate{
+	{URL: "https://a.example/", Title: "A", Snippet: "first"},
+	{URL: "https://b.example/", Title: "B", Snippet: "second"},
+	{URL: "https://c.example/", Title: "C", Snippet: "third"},
+}
+
+func TestAPIRequest(t *testing.T) {
+	var got map[string]any
+	var path, auth, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		path, auth, contentType = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		w.Write([]byte(`{"results": [{"index": 0, "relevance_score": 0.5}]}`))
+	}))
+	defer srv.Close()
+
+	r := &API{BaseURL: srv.URL + "/v1/", APIKey: "secret", Model: "rerank-model"}
+	if _, err := r.Rerank(context.Background(), apiCandidates, "query", &Options{Results: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"model":            "rerank-model",
+		"query":            "query",
+		"documents":        []any{"A\nfirst", "B\nsecond", "C\nthird"},
+		"top_n":            float64(2),
+		"return_documents": false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request body = %v, want %v", got, want)
+	}
+	if path != "/v1/rerank" {
+		t.Errorf("request path = %q, want /v1/rerank", path)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want Bearer secret", auth)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+
+	// Without an API key no Authorization header is sent, and top_n is
+	// capped at the number of candidates.
+	r = &API{BaseURL: srv.URL}
+	if _, err := r.Rerank(context.Background(), apiCandidates, "query", &Options{Results: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		t.Errorf("Authorization = %q, want none", auth)
+	}
+	if _, ok := got["model"]; ok {
+		t.Errorf("request body has a model without one configured: %v", got)
+	}
+	if got["top_n"] != float64(len(apiCandidates)) {
+		t.Errorf("top_n = %v, want %d", got["top_n"], len(apiCandidates))
+	}
+}
+
+func TestAPIResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		results int
+		want    []Ranked
+		wantErr bool
+	}{
+		{
+			name: "results",
+			body: `{"results": [{"index": 2, "relevance_score": 0.9}, {"index": 0, "relevance_score": 0.4}]}`,
+			want: []Ranked{{Index: 2, Score: 0.9, Scored: true}, {Index: 0, Score: 0.4, Scored: true}},
+		},
+		{
+			name: "unsorted results are sorted by score",
+			body: `{"results": [{"index": 0, "relevance_score": 0.1}, {"index": 1, "relevance_score": 0.8}]}`,
+			want: []Ranked{{Index: 1, Score: 0.8, Scored: true}, {Index: 0, Score: 0.1, Scored: true}},
+		},
+		{
+			name: "out of range and duplicate indexes are ignored",
+			body: `{"results": [{"index": 5, "relevance_score": 0.99}, {"index": 1, "relevance_score": 0.9}, {"index": -1, "relevance_score": 0.8}, {"index": 1, "relevance_score": 0.7}, {"index": 2, "relevance_score": 0.6}]}`,
+			want: []Ranked{{Index: 1, Score: 0.9, Scored: true}, {Index: 2, Score: 0.6, Scored: true}},
+		},
+		{
+			name:    "cut to the results",
+			body:    `{"results": [{"index": 0, "relevance_score": 0.9}, {"index": 1, "relevance_score": 0.8}, {"index": 2, "relevance_score": 0.7}]}`,
+			results: 2,
+			want:    []Ranked{{Index: 0, Score: 0.9, Scored: true}, {Index: 1, Score: 0.8, Scored: true}},
+		},
+		{name: "no valid index", body: `{"results": [{"index": 3, "relevance_score": 0.9}]}`, wantErr: true},
+		{name: "status code error", status: http.StatusTooManyRequests, body: `{"message": "rate limited"}`, wantErr: true},
+		{name: "malformed JSON", body: `{"results": [{"index": 0,`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			r := &API{BaseURL: srv.URL}
+			got, err := r.Rerank(context.Background(), apiCandidates, "query", &Options{Results: tt.results})
+			if tt.wantErr {
+				if !errors.Is(err, ErrRerankFailed) {
+					t.Fatalf("Rerank() = %+v, %v, want ErrRerankFailed", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Rerank() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rerank() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
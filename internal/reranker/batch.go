@@ -0,0 +1,262 @@
+package reranker
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/lemon-mint/infofluss/internal/tokens"
+)
+
+const (
+	// DefaultBatchTokens is the default token budget of the candidates of
+	// one window.
+	DefaultBatchTokens = 16000
+
+	// candidateOverheadTokens covers the markup around every candidate.
+	candidateOverheadTokens = 24
+
+	// batchConcurrency bounds the number of windows ranked at once.
+	batchConcurrency = 4
+)
+
+// Batched is a Reranker that ranks candidate sets too large for one prompt of
+// Next in a tournament: the candidates are split into windows that fit the
+// token budget, every window is ranked on its own, and the winners of all
+// windows advance to the next round, until they fit in a single window that
+// makes the final ranking.
+//
+// If all candidates are to be ranked (e.g. by RerankContents), no round could
+// eliminate any, so every window is ranked on its own and the rankings are
+// merged instead.
+type Batched struct {
+	Next Reranker
+	// TokenBudget is the estimated tokens of the candidates of one window
+	// (default: DefaultBatchTokens).
+	TokenBudget int
+}
+
+func (r *Batched) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	budget := r.TokenBudget
+	if budget <= 0 {
+		budget = DefaultBatchTokens
+	}
+	winners := opts.results()
+
+	size := make([]int, len(candidates))
+	for i := range candidates {
+		c := &candidates[i]
+		size[i] = tokens.Estimate(c.URL+c.Title+c.Snippet) + candidateOverheadTokens
+	}
+
+	// remaining holds the indexes of the candidates still in the tournament.
+	remaining := make([]int, len(candidates))
+	for i := range remaining {
+		remaining[i] = i
+	}
+	if winners >= len(candidates) {
+		return r.rankWindows(ctx, candidates, remaining, size, budget, query, opts)
+	}
+	for {
+		windows := splitWindows(remaining, size, budget, winners+1)
+		if len(windows) <= 1 {
+			break
+		}
+
+		results := make([][]int, len(windows))
+		errs := make([]error, len(windows))
+		wg := &sync.WaitGroup{}
+		sem := make(chan struct{}, batchConcurrency)
+		for w, window := range windows {
+			if len(window) <= winners {
+				results[w] = window
+				continue
+			}
+			wg.Add(1)
+			go func(w int, window []int) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				order, err := r.rank(ctx, candidates, window, query, opts, winners)
+				if err != nil {
+					// Let the window advance its first candidates in the
+					// order they came.
+					errs[w] = err
+					order = window[:winners]
+				}
+				results[w] = order
+			}(w, window)
+		}
+		wg.Wait()
+
+		failed := 0
+		for _, err := range errs {
+			if err != nil {
+				failed++
+			}
+		}
+		if failed == len(windows) {
+			return nil, errs[0]
+		}
+
+		// Interleave the winners by their rank in their window, so that the
+		// best of every window come first in the next round.
+		remaining = remaining[:0]
+		for rank := 0; ; rank++ {
+			found := false
+			for _, order := range results {
+				if rank < len(order) {
+					remaining = append(remaining, order[rank])
+					found = true
+				}
+			}
+			if !found {
+				break
+			}
+		}
+	}
+
+	final := make([]Candidate, len(remaining))
+	for i, index := range remaining {
+		final[i] = candidates[index]
+	}
+	order, err := r.Next.Rerank(ctx, final, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range order {
+		order[i].Index = remaining[order[i].Index]
+	}
+	return order, nil
+}
+
+// rankWindows ranks the candidates of indexes in windows that fit the budget,
+// keeping all of them, and merges the rankings of the windows: by score if
+// every ranking is scored, otherwise by interleaving them by rank. A window
+// that fails keeps its candidates in the order they came, unless all fail.
+func (r *Batched) rankWindows(ctx context.Context, candidates []Candidate, indexes, size []int, budget int, query string, opts *Options) ([]Ranked, error) {
+	windows := splitWindows(indexes, size, budget, 1)
+	if len(windows) <= 1 {
+		return r.Next.Rerank(ctx, candidates, query, opts)
+	}
+
+	results := make([][]Ranked, len(windows))
+	errs := make([]error, len(windows))
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, batchConcurrency)
+	for w, window := range windows {
+		wg.Add(1)
+		go func(w int, window []int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			order, err := r.rankWindow(ctx, candidates, window, query, opts, len(window))
+			if err != nil {
+				errs[w] = err
+				order = make([]Ranked, len(window))
+				for i, index := range window {
+					order[i] = Ranked{Index: index}
+				}
+			}
+			results[w] = order
+		}(w, window)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed == len(windows) {
+		return nil, errs[0]
+	}
+
+	var merged []Ranked
+	scored := true
+	for rank := 0; ; rank++ {
+		found := false
+		for _, order := range results {
+			if rank < len(order) {
+				merged = append(merged, order[rank])
+				scored = scored && order[rank].Scored
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	if scored {
+		sort.SliceStable(merged, func(a, b int) bool {
+			return merged[a].Score > merged[b].Score
+		})
+	}
+	return merged, nil
+}
+
+// rank ranks the candidates of one window with Next, returning the indexes of
+// up to n winners.
+func (r *Batched) rank(ctx context.Context, candidates []Candidate, window []int, query string, opts *Options, n int) ([]int, error) {
+	order, err := r.rankWindow(ctx, candidates, window, query, opts, n)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]int, 0, n)
+	for _, ranked := range order {
+		if len(result) == n {
+			break
+		}
+		result = append(result, ranked.Index)
+	}
+	return result, nil
+}
+
+// rankWindow ranks the candidates of one window with Next, asking for n
+// results, and returns the ranking with the indexes of candidates.
+func (r *Batched) rankWindow(ctx context.Context, candidates []Candidate, window []int, query string, opts *Options, n int) ([]Ranked, error) {
+	subset := make([]Candidate, len(window))
+	for i, index := range window {
+		subset[i] = candidates[index]
+	}
+
+	windowOpts := Options{Results: n}
+	if opts != nil {
+		windowOpts = *opts
+		windowOpts.Results = n
+	}
+	order, err := r.Next.Rerank(ctx, subset, query, &windowOpts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range order {
+		order[i].Index = window[order[i].Index]
+	}
+	return order, nil
+}
+
+// splitWindows splits the candidates of indexes, in order, into windows whose
+// sizes add up to at most budget. Every window but the last holds at least
+// minLen candidates even if they exceed the budget, so that every round of the
+// tournament eliminates candidates.
+func splitWindows(indexes, size []int, budget, minLen int) [][]int {
+	var windows [][]int
+	var window []int
+	used := 0
+	for _, index := range indexes {
+		if len(window) >= minLen && used+size[index] > budget {
+			windows = append(windows, window)
+			window, used = nil, 0
+		}
+		window = append(window, index)
+		used += size[index]
+	}
+	if len(window) > 0 {
+		windows = append(windows, window)
+	}
+	return windows
+}
@@ -0,0 +1,198 @@
+package reranker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lemon-mint/infofluss/internal/tokens"
+)
+
+// scoreReranker ranks candidates by the score in their snippet. It fails on
+// the candidate sets fail returns true for, and records the sizes of the
+// sets it was given.
+type scoreReranker struct {
+	fail func(candidates []Candidate) bool
+
+	mu    sync.Mutex
+	sizes []int
+}
+
+var errRanking = errors.New("ranking failed")
+
+func (r *scoreReranker) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	r.mu.Lock()
+	r.sizes = append(r.sizes, len(candidates))
+	r.mu.Unlock()
+	if r.fail != nil && r.fail(candidates) {
+		return nil, errRanking
+	}
+
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		scores[i], _ = strconv.ParseFloat(c.Snippet, 64)
+	}
+	order := rankByScore(scores)
+	if n := opts.results(); len(order) > n {
+		order = order[:n]
+	}
+	ranked := make([]Ranked, len(order))
+	for i, index := range order {
+		ranked[i] = Ranked{Index: index, Score: scores[index], Scored: true}
+	}
+	return ranked, nil
+}
+
+// scoredCandidates returns candidates with the given scores.
+func scoredCandidates(scores ...float64) []Candidate {
+	candidates := make([]Candidate, len(scores))
+	for i, score := range scores {
+		candidates[i] = Candidate{
+			URL:     "https://example.com/" + strconv.Itoa(i),
+			Snippet: strconv.FormatFloat(score, 'f', -1, 64),
+		}
+	}
+	return candidates
+}
+
+func TestSplitWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		indexes []int
+		size    []int
+		budget  int
+		minLen  int
+		want    [][]int
+	}{
+		{name: "budget", indexes: []int{0, 1, 2, 3}, size: []int{5, 5, 5, 5}, budget: 10, minLen: 1, want: [][]int{{0, 1}, {2, 3}}},
+		{name: "minimum length over the budget", indexes: []int{0, 1, 2, 3}, size: []int{5, 5, 5, 5}, budget: 10, minLen: 3, want: [][]int{{0, 1, 2}, {3}}},
+		{name: "oversized candidate alone", indexes: []int{0, 1, 2}, size: []int{20, 5, 5}, budget: 10, minLen: 1, want: [][]int{{0}, {1, 2}}},
+		{name: "order of the indexes kept", indexes: []int{3, 0, 2}, size: []int{1, 1, 1, 1}, budget: 2, minLen: 1, want: [][]int{{3, 0}, {2}}},
+		{name: "single window", indexes: []int{0, 1}, size: []int{1, 1}, budget: 10, minLen: 1, want: [][]int{{0, 1}}},
+		{name: "no candidates", size: nil, budget: 10, minLen: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitWindows(tt.indexes, tt.size, tt.budget, tt.minLen); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatched(t *testing.T) {
+	scores := []float64{0.1, 0.5, 0.2, 0.9, 0.3, 0.4, 0.8, 0.6, 0.05, 0.7}
+	candidates := scoredCandidates(scores...)
+
+	// A budget of 1 token makes windows of the minimum length: the winners
+	// and one more.
+	next := &scoreReranker{}
+	r := &Batched{Next: next, TokenBudget: 1}
+	got, err := r.Rerank(context.Background(), candidates, "query", &Options{Results: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Ranked{{Index: 3, Score: 0.9, Scored: true}, {Index: 6, Score: 0.8, Scored: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rerank() = %+v, want %+v", got, want)
+	}
+	for _, size := range next.sizes {
+		if size > 3 {
+			t.Errorf("Next ranked %d candidates at once, want at most 3", size)
+		}
+	}
+
+	// Candidates that fit the budget are ranked at once.
+	next = &scoreReranker{}
+	r = &Batched{Next: next}
+	got, err = r.Rerank(context.Background(), candidates, "query", &Options{Results: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(next.sizes, []int{len(candidates)}) {
+		t.Errorf("Rerank() = %+v with sets of %v, want %+v with one set", got, next.sizes, want)
+	}
+}
+
+func TestBatchedFailedWindow(t *testing.T) {
+	candidates := scoredCandidates(0.1, 0.2, 0.9, 0.3, 0.8, 0.7)
+
+	// The window of the best candidate fails and advances its first two
+	// candidates instead.
+	next := &scoreReranker{fail: func(candidates []Candidate) bool {
+		return len(candidates) == 3 && candidates[2].URL == "https://example.com/2"
+	}}
+	r := &Batched{Next: next, TokenBudget: 1}
+	got, err := r.Rerank(context.Background(), candidates, "query", &Options{Results: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{4, 5}; !reflect.DeepEqual(indexes(got), want) {
+		t.Errorf("Rerank() = %v, want %v", indexes(got), want)
+	}
+
+	// When every window fails, so does the ranking.
+	next = &scoreReranker{fail: func([]Candidate) bool { return true }}
+	r = &Batched{Next: next, TokenBudget: 1}
+	if _, err := r.Rerank(context.Background(), candidates, "query", &Options{Results: 2}); !errors.Is(err, errRanking) {
+		t.Errorf("Rerank() error = %v, want %v", err, errRanking)
+	}
+	sort.Ints(next.sizes)
+	if !reflect.DeepEqual(next.sizes, []int{3, 3}) {
+		t.Errorf("Next ranked sets of %v, want only the first round", next.sizes)
+	}
+}
+
+func TestBatchedAllResults(t *testing.T) {
+	candidates := scoredCandidates(0.1, 0.5, 0.2, 0.9, 0.3, 0.4, 0.8)
+	size := tokens.Estimate(candidates[0].URL+candidates[0].Snippet) + candidateOverheadTokens
+	ranked := func(pairs ...float64) []Ranked {
+		var want []Ranked
+		for i := 0; i < len(pairs); i += 2 {
+			want = append(want, Ranked{Index: int(pairs[i]), Score: pairs[i+1], Scored: true})
+		}
+		return want
+	}
+
+	// Keeping every candidate, the windows are ranked on their own and
+	// merged by score instead of sending all candidates at once.
+	next := &scoreReranker{}
+	r := &Batched{Next: next, TokenBudget: 3 * size}
+	got, err := r.Rerank(context.Background(), candidates, "query", &Options{Results: len(candidates)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ranked(3, 0.9, 6, 0.8, 1, 0.5, 5, 0.4, 4, 0.3, 2, 0.2, 0, 0.1)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rerank() = %+v, want %+v", got, want)
+	}
+	sort.Ints(next.sizes)
+	if !reflect.DeepEqual(next.sizes, []int{1, 3, 3}) {
+		t.Errorf("Next ranked sets of %v, want windows of at most 3", next.sizes)
+	}
+
+	// A failed window keeps its candidates unscored, so the rankings are
+	// interleaved by rank.
+	next = &scoreReranker{fail: func(candidates []Candidate) bool {
+		return candidates[0].URL == "https://example.com/3"
+	}}
+	r = &Batched{Next: next, TokenBudget: 3 * size}
+	got, err = r.Rerank(context.Background(), candidates, "query", &Options{Results: len(candidates)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 3, 6, 2, 4, 0, 5}; !reflect.DeepEqual(indexes(got), want) {
+		t.Errorf("Rerank() = %v, want %v", indexes(got), want)
+	}
+
+	next = &scoreReranker{fail: func([]Candidate) bool { return true }}
+	r = &Batched{Next: next, TokenBudget: 3 * size}
+	if _, err := r.Rerank(context.Background(), candidates, "query", &Options{Results: len(candidates)}); !errors.Is(err, errRanking) {
+		t.Errorf("Rerank() error = %v, want %v", err, errRanking)
+	}
+}
@@ -0,0 +1,72 @@
+package reranker
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/lemon-mint/coord/llm"
+)
+
+// DefaultResults is the number of candidates selected by a reranker.
+const DefaultResults = 4
+
+// Candidate is a search result to be ranked.
+type Candidate struct {
+	URL     string
+	Title   string
+	Snippet string
+	Engines []string // search engines that returned the result
+
+	PublishedDate string // publication date reported by the search engine, if any
+}
+
+// Text returns the text of the candidate used for lexical and semantic
+// matching: its title and snippet.
+func (c *Candidate) Text() string {
+	return c.Title + "\n" + c.Snippet
+}
+
+// Ranked is a candidate selected by a Reranker.
+type Ranked struct {
+	Index int // index of the candidate
+
+	// Score is the relevance of the candidate to the query, higher is
+	// better. Its scale depends on the reranker: 0 to 1 for the prompt-based
+	// reranker and rerank services, the cosine similarity for embeddings, and
+	// the share of the best score for the lexical reranker. Scored is false
+	// if the reranker gave no score.
+	Score  float64
+	Scored bool
+
+	Rationale string // why the candidate was selected, if the reranker says
+}
+
+// Reranker orders search results by relevance to a query. Rerank returns up
+// to opts.Results candidates, most relevant first; opts may be nil.
+type Reranker interface {
+	Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error)
+}
+
+// LLM is the prompt-based Reranker: a chat model reads the candidates and
+// picks the most relevant ones (see RerankDocuments).
+type LLM struct {
+	Model llm.Model
+}
+
+func (r *LLM) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	documents := make([]string, len(candidates))
+	for i, c := range candidates {
+		type InputFormat struct {
+			URL     string `json:"url"`
+			Title   string `json:"title"`
+			Snippet string `json:"snippet"`
+		}
+		data, _ := json.Marshal(InputFormat{
+			URL:     c.URL,
+			Title:   c.Title,
+			Snippet: c.Snippet,
+		})
+		documents[i] = string(data)
+	}
+	return RerankDocuments(ctx, r.Model, documents, query, opts)
+}
@@ -0,0 +1,120 @@
+package reranker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/passage"
+	"github.com/lemon-mint/infofluss/internal/tokens"
+)
+
+const (
+	// DefaultExcerptTokens is the size of the excerpt of a page the reranker
+	// reads.
+	DefaultExcerptTokens = 1000
+	// DefaultMinContentTokens is the size below which a page counts as empty.
+	DefaultMinContentTokens = 30
+)
+
+type ContentStatus string
+
+const (
+	ContentStatusKept       ContentStatus = "kept"
+	ContentStatusEmpty      ContentStatus = "empty"
+	ContentStatusIrrelevant ContentStatus = "irrelevant"
+)
+
+// ContentReport records what happened to a document during content
+// reranking.
+type ContentReport struct {
+	Source    string        `json:"source"`
+	Status    ContentStatus `json:"status"`
+	Score     *float64      `json:"score,omitempty"`
+	Rationale string        `json:"rationale,omitempty"`
+}
+
+// ContentOptions control RerankContents. Zero values use the defaults.
+type ContentOptions struct {
+	ExcerptTokens int     // tokens of the page text the reranker reads
+	MinTokens     int     // pages with less text are dropped as empty
+	MinScore      float64 // pages scored below it are dropped (0 keeps all)
+}
+
+// RerankContents ranks crawled documents by their content instead of their
+// search snippet. Every text document is handed to r as a candidate with an
+// excerpt of its text; documents with (almost) no text, and documents that r
+// leaves out or scores below opts.MinScore, are dropped. Documents that are
+// not text (e.g. page screenshots) are kept after the ranked ones.
+//
+// If r fails, the documents keep their order, without the empty ones, and
+// the error is returned with them.
+func RerankContents(ctx context.Context, r Reranker, documents []chat.Document, query string, opts ContentOptions) ([]chat.Document, []ContentReport, error) {
+	if opts.ExcerptTokens <= 0 {
+		opts.ExcerptTokens = DefaultExcerptTokens
+	}
+	if opts.MinTokens <= 0 {
+		opts.MinTokens = DefaultMinContentTokens
+	}
+
+	reports := make([]ContentReport, len(documents))
+	var candidates []Candidate
+	var indexes []int // document of every candidate
+	var whole []int   // documents that are not text
+	for i, doc := range documents {
+		reports[i] = ContentReport{Source: doc.Source, Status: ContentStatusKept}
+		text, ok := passage.Text(doc)
+		if !ok {
+			whole = append(whole, i)
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if tokens.Estimate(text) < opts.MinTokens {
+			reports[i].Status = ContentStatusEmpty
+			continue
+		}
+
+		c := Candidate{URL: doc.Source, Snippet: tokens.Truncate(text, opts.ExcerptTokens)}
+		if doc.Metadata != nil {
+			c.Title = doc.Metadata.Title
+			c.PublishedDate = doc.Metadata.PublishedTime
+		}
+		candidates = append(candidates, c)
+		indexes = append(indexes, i)
+	}
+
+	var order []Ranked
+	var err error
+	if len(candidates) > 0 {
+		order, err = Select(ctx, r, candidates, query, &Options{
+			Results:        len(candidates),
+			AvoidFileTypes: []string{},
+			MinScore:       opts.MinScore,
+		})
+		if err != nil {
+			order = FallbackOrder(candidates, query, FallbackEngine, nil, len(candidates))
+		}
+	}
+
+	result := make([]chat.Document, 0, len(documents))
+	selected := make([]bool, len(candidates))
+	for _, ranked := range order {
+		i := indexes[ranked.Index]
+		selected[ranked.Index] = true
+		result = append(result, documents[i])
+		if ranked.Scored {
+			score := ranked.Score
+			reports[i].Score = &score
+		}
+		reports[i].Rationale = ranked.Rationale
+	}
+	for c, ok := range selected {
+		if !ok {
+			reports[indexes[c]].Status = ContentStatusIrrelevant
+		}
+	}
+	for _, i := range whole {
+		result = append(result, documents[i])
+	}
+	return result, reports, err
+}
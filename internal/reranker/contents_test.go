@@ -0,0 +1,133 @@
+package reranker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/chat"
+)
+
+func TestRerankContents(t *testing.T) {
+	text := func(source string) chat.Document {
+		return chat.Document{Source: source, Contents: []llm.Segment{llm.Text(strings.Repeat("word ", 100))}}
+	}
+	documents := []chat.Document{
+		text("a"),
+		{Source: "b", Contents: []llm.Segment{llm.Text("  tiny  ")}},
+		{Source: "c", Contents: []llm.Segment{&llm.InlineData{MIMEType: "image/png", Data: []byte{0}}}},
+		text("d"),
+		text("e"),
+	}
+	// The candidates are a, d and e.
+	order := []Ranked{{Index: 2, Score: 0.9, Scored: true, Rationale: "best"}, {Index: 0, Score: 0.2, Scored: true}}
+	score := func(s float64) *float64 { return &s }
+
+	tests := []struct {
+		name        string
+		r           *fixedReranker
+		minScore    float64
+		want        []string
+		wantReports []ContentReport
+		wantErr     bool
+	}{
+		{
+			name: "ranked",
+			r:    &fixedReranker{order: order},
+			want: []string{"e", "a", "c"},
+			wantReports: []ContentReport{
+				{Source: "a", Status: ContentStatusKept, Score: score(0.2)},
+				{Source: "b", Status: ContentStatusEmpty},
+				{Source: "c", Status: ContentStatusKept},
+				{Source: "d", Status: ContentStatusIrrelevant},
+				{Source: "e", Status: ContentStatusKept, Score: score(0.9), Rationale: "best"},
+			},
+		},
+		{
+			name:     "minimum score",
+			r:        &fixedReranker{order: order},
+			minScore: 0.5,
+			want:     []string{"e", "c"},
+			wantReports: []ContentReport{
+				{Source: "a", Status: ContentStatusIrrelevant},
+				{Source: "b", Status: ContentStatusEmpty},
+				{Source: "c", Status: ContentStatusKept},
+				{Source: "d", Status: ContentStatusIrrelevant},
+				{Source: "e", Status: ContentStatusKept, Score: score(0.9), Rationale: "best"},
+			},
+		},
+		{
+			name: "reranker failure keeps the order",
+			r:    &fixedReranker{err: errors.New("unavailable")},
+			want: []string{"a", "d", "e", "c"},
+			wantReports: []ContentReport{
+				{Source: "a", Status: ContentStatusKept},
+				{Source: "b", Status: ContentStatusEmpty},
+				{Source: "c", Status: ContentStatusKept},
+				{Source: "d", Status: ContentStatusKept},
+				{Source: "e", Status: ContentStatusKept},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reports, err := RerankContents(context.Background(), tt.r, documents, "query", ContentOptions{MinScore: tt.minScore})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RerankContents() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var sources []string
+			for _, doc := range got {
+				sources = append(sources, doc.Source)
+			}
+			if !reflect.DeepEqual(sources, tt.want) {
+				t.Errorf("RerankContents() = %v, want %v", sources, tt.want)
+			}
+			if !reflect.DeepEqual(reports, tt.wantReports) {
+				t.Errorf("RerankContents() reports = %+v, want %+v", reports, tt.wantReports)
+			}
+		})
+	}
+
+	// The reranker reads an excerpt of every page.
+	r := &recordingReranker{}
+	RerankContents(context.Background(), r, documents[:1], "query", ContentOptions{ExcerptTokens: 10})
+	if len(r.candidates) != 1 || len(r.candidates[0].Snippet) > 10*4 {
+		t.Errorf("RerankContents() passed %+v, want an excerpt of 10 tokens", r.candidates)
+	}
+
+	// A batched reranker ranks windows of excerpts that fit its budget
+	// instead of all of them at once, and keeps every page.
+	var many []chat.Document
+	for i := 0; i < 6; i++ {
+		many = append(many, text(strings.Repeat("x", i+1)))
+	}
+	next := &scoreReranker{}
+	got, _, err := RerankContents(context.Background(), &Batched{Next: next, TokenBudget: 300}, many, "query", ContentOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(many) {
+		t.Errorf("RerankContents() kept %d of %d pages", len(got), len(many))
+	}
+	for _, size := range next.sizes {
+		if size > 2 {
+			t.Errorf("Next ranked %d excerpts at once, want at most 2", size)
+		}
+	}
+}
+
+// recordingReranker records the candidates it was given and keeps their
+// order.
+type recordingReranker struct {
+	candidates []Candidate
+}
+
+func (r *recordingReranker) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	r.candidates = candidates
+	return ranking(0), nil
+}
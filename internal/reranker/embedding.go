@@ -0,0 +1,97 @@
+package reranker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/lemon-mint/coord/embedding"
+)
+
+// embeddingConcurrency bounds the number of embedding requests in flight.
+const embeddingConcurrency = 8
+
+// Embedding is a Reranker that embeds the query and the title and snippet of
+// every candidate, and orders the candidates by cosine similarity to the
+// query. It makes no chat completion, but embedding.Model embeds one text per
+// call, so it makes one call for the query and one per candidate, at most
+// embeddingConcurrency at a time. Candidates that fail to embed are dropped.
+type Embedding struct {
+	Model embedding.Model
+}
+
+func (r *Embedding) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	if len(candidates) == 0 {
+		return nil, ErrRerankFailed
+	}
+	limit := opts.results()
+
+	q, err := r.Model.TextEmbedding(ctx, query, embedding.TaskTypeSearchQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRerankFailed, err)
+	}
+
+	scores := make([]float64, len(candidates))
+	embedded := make([]bool, len(candidates))
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, embeddingConcurrency)
+	for i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			v, err := r.Model.TextEmbedding(ctx, candidates[i].Text(), embedding.TaskTypeSearchDocument)
+			if err != nil {
+				return
+			}
+			scores[i] = cosineSimilarity(q, v)
+			embedded[i] = true
+		}(i)
+	}
+	wg.Wait()
+
+	var order []int
+	for i := range candidates {
+		if embedded[i] {
+			order = append(order, i)
+		}
+	}
+	if len(order) == 0 {
+		return nil, ErrRerankFailed
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	if len(order) > limit {
+		order = order[:limit]
+	}
+	ranked := make([]Ranked, len(order))
+	for i, index := range order {
+		ranked[i] = Ranked{Index: index, Score: scores[index], Scored: true}
+	}
+	return ranked, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
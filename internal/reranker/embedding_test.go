@@ -0,0 +1,144 @@
+package reranker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lemon-mint/coord/embedding"
+)
+
+// vectorModel embeds texts by looking them up in vectors, and fails for texts
+// it has no vector for. It records the number of calls in flight.
+type vectorModel struct {
+	vectors map[string][]float64
+
+	mu       sync.Mutex
+	calls    int
+	inFlight int
+	peak     int
+}
+
+func (m *vectorModel) TextEmbedding(ctx context.Context, text string, task embedding.TaskType) ([]float64, error) {
+	m.mu.Lock()
+	m.calls++
+	m.inFlight++
+	if m.inFlight > m.peak {
+		m.peak = m.inFlight
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+
+	v, ok := m.vectors[text]
+	if !ok {
+		return nil, embedding.ErrNoResult
+	}
+	return v, nil
+}
+
+func TestEmbedding(t *testing.T) {
+	candidates := []Candidate{
+		{Title: "A", Snippet: "orthogonal"},
+		{Title: "B", Snippet: "close"},
+		{Title: "C", Snippet: "fails"},
+		{Title: "D", Snippet: "same"},
+		{Title: "E", Snippet: "opposite"},
+	}
+	m := &vectorModel{vectors: map[string][]float64{
+		"query":         {1, 0},
+		"A\northogonal": {0, 1},
+		"B\nclose":      {1, 1},
+		"D\nsame":       {2, 0},
+		"E\nopposite":   {-1, 0},
+	}}
+	r := &Embedding{Model: m}
+
+	ranked, err := r.Rerank(context.Background(), candidates, "query", &Options{Results: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The candidate that fails to embed is dropped.
+	if got, want := indexes(ranked), []int{3, 1, 0, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+	if ranked[0].Score != 1 || !ranked[0].Scored {
+		t.Errorf("best = %+v, want a score of 1", ranked[0])
+	}
+	if m.calls != len(candidates)+1 {
+		t.Errorf("calls = %d, want %d", m.calls, len(candidates)+1)
+	}
+
+	ranked, err = r.Rerank(context.Background(), candidates, "query", &Options{Results: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexes(ranked), []int{3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("limited order = %v, want %v", got, want)
+	}
+}
+
+func TestEmbeddingConcurrency(t *testing.T) {
+	m := &vectorModel{vectors: map[string][]float64{"query": {1}}}
+	var candidates []Candidate
+	for i := 0; i < 4*embeddingConcurrency; i++ {
+		c := Candidate{Title: strings.Repeat("t", i+1)}
+		m.vectors[c.Text()] = []float64{float64(i)}
+		candidates = append(candidates, c)
+	}
+	if _, err := (&Embedding{Model: m}).Rerank(context.Background(), candidates, "query", nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.peak > embeddingConcurrency {
+		t.Errorf("%d calls in flight, want at most %d", m.peak, embeddingConcurrency)
+	}
+}
+
+func TestEmbeddingErrors(t *testing.T) {
+	candidates := []Candidate{{Title: "A", Snippet: "a"}}
+	tests := []struct {
+		name       string
+		vectors    map[string][]float64
+		candidates []Candidate
+	}{
+		{name: "no candidates", vectors: map[string][]float64{"query": {1}}},
+		{name: "query fails", vectors: map[string][]float64{"A\na": {1}}, candidates: candidates},
+		{name: "every candidate fails", vectors: map[string][]float64{"query": {1}}, candidates: candidates},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Embedding{Model: &vectorModel{vectors: tt.vectors}}
+			if _, err := r.Rerank(context.Background(), tt.candidates, "query", nil); !errors.Is(err, ErrRerankFailed) {
+				t.Errorf("Rerank() error = %v, want %v", err, ErrRerankFailed)
+			}
+		})
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{name: "same direction", a: []float64{1, 2}, b: []float64{2, 4}, want: 1},
+		{name: "orthogonal", a: []float64{1, 0}, b: []float64{0, 3}, want: 0},
+		{name: "opposite", a: []float64{1, 1}, b: []float64{-1, -1}, want: -1},
+		{name: "zero vector", a: []float64{0, 0}, b: []float64{1, 1}, want: 0},
+		{name: "length mismatch", a: []float64{1, 0}, b: []float64{1, 0, 0}, want: 0},
+		{name: "empty", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := cosineSimilarity(tt.a, tt.b); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("%s: cosineSimilarity(%v, %v) = %v, want %v", tt.name, tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package reranker
+
+// Fallback orders used when reranking fails.
+const (
+	FallbackEngine  = "engine"  // keep the order of the search engine
+	FallbackLexical = "lexical" // order by BM25 score against the query
+)
+
+// FallbackOrder ranks candidates without a model, for when a Reranker fails.
+// With FallbackEngine the candidates keep their order; with FallbackLexical
+// (the default) they are ordered by their score with the Lexical reranker
+// using the domain weights of trust, ties keeping their order. At most limit
+// candidates are returned, without scores.
+func FallbackOrder(candidates []Candidate, query, mode string, trust map[string]float64, limit int) []Ranked {
+	if limit <= 0 {
+		limit = DefaultResults
+	}
+
+	var order []int
+	if mode == FallbackEngine {
+		order = make([]int, len(candidates))
+		for i := range order {
+			order[i] = i
+		}
+	} else {
+		order = rankByScore((&Lexical{DomainTrust: trust}).Scores(candidates, query))
+	}
+
+	if len(order) > limit {
+		order = order[:limit]
+	}
+	ranked := make([]Ranked, len(order))
+	for i, index := range order {
+		ranked[i] = Ranked{Index: index}
+	}
+	return ranked
+}
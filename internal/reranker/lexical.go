@@ -0,0 +1,206 @@
+package reranker
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lemon-mint/infofluss/internal/bm25"
+)
+
+// Weights of the components of the lexical score. The BM25 score is
+// normalized to [0, 1] within the candidate set.
+const (
+	lexicalWeight   = 1.0
+	agreementWeight = 0.3  // returned by every engine of the candidate set
+	recencyWeight   = 0.2  // published today
+	positionWeight  = 0.05 // first in the engine order
+)
+
+// recencyHalfLife is the age at which the recency boost is halved.
+const recencyHalfLife = 365 * 24 * time.Hour
+
+// Lexical is a Reranker that runs entirely in-process, without model calls.
+// Candidates are scored with BM25 over their title and snippet, plus a bonus
+// for being returned by several search engines and for being recent, and
+// the score is multiplied by the trust weight of their domain.
+type Lexical struct {
+	// DomainTrust maps domains to score multipliers, e.g. 1.5 for an
+	// official documentation site or 0.5 for a content farm. A domain also
+	// matches its subdomains.
+	DomainTrust map[string]float64
+	// Now returns the current time (default: time.Now).
+	Now func() time.Time
+}
+
+func (r *Lexical) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	if len(candidates) == 0 {
+		return nil, ErrRerankFailed
+	}
+	limit := opts.results()
+
+	scores := r.Scores(candidates, query)
+	order := rankByScore(scores)
+	if len(order) > limit {
+		order = order[:limit]
+	}
+	best := scores[order[0]]
+	ranked := make([]Ranked, len(order))
+	for i, index := range order {
+		ranked[i] = Ranked{Index: index}
+		if best > 0 {
+			ranked[i].Score, ranked[i].Scored = scores[index]/best, true
+		}
+	}
+	return ranked, nil
+}
+
+// Scores returns the lexical score of every candidate.
+func (r *Lexical) Scores(candidates []Candidate, query string) []float64 {
+	now := time.Now()
+	if r.Now != nil {
+		now = r.Now()
+	}
+
+	texts := make([]string, len(candidates))
+	for i := range candidates {
+		texts[i] = candidates[i].Text()
+	}
+	lexical := bm25.New(texts).Score(query)
+	var maxLexical float64
+	for _, s := range lexical {
+		maxLexical = math.Max(maxLexical, s)
+	}
+
+	engines := make(map[string]bool)
+	for _, c := range candidates {
+		for _, e := range c.Engines {
+			engines[e] = true
+		}
+	}
+
+	scores := make([]float64, len(candidates))
+	for i, c := range candidates {
+		var score float64
+		if maxLexical > 0 {
+			score += lexicalWeight * lexical[i] / maxLexical
+		}
+		if len(engines) > 1 && len(c.Engines) > 1 {
+			score += agreementWeight * float64(len(c.Engines)-1) / float64(len(engines)-1)
+		}
+		if published, ok := candidateDate(&c); ok {
+			age := now.Sub(published)
+			if age < 0 {
+				age = 0
+			}
+			score += recencyWeight * math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+		}
+		score += positionWeight * (1 - float64(i)/float64(len(candidates)))
+		scores[i] = score * r.trust(c.URL)
+	}
+	return scores
+}
+
+// trust returns the trust weight of the domain of rawURL, matching the most
+// specific configured domain.
+func (r *Lexical) trust(rawURL string) float64 {
+	if len(r.DomainTrust) == 0 {
+		return 1
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 1
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	for {
+		if w, ok := r.DomainTrust[host]; ok {
+			return w
+		}
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok {
+			return 1
+		}
+		host = parent
+	}
+}
+
+// rankByScore returns the indexes of scores from highest to lowest score,
+// ties keeping their order.
+func rankByScore(scores []float64) []int {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	return order
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+}
+
+var urlDate = regexp.MustCompile(`/((?:19|20)\d{2})/(0?[1-9]|1[0-2])(?:/|$)`)
+
+// candidateDate returns the publication date of a candidate, taken from the
+// search engine or from a /yyyy/mm/ segment of its URL.
+func candidateDate(c *Candidate) (time.Time, bool) {
+	if c.PublishedDate != "" {
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, c.PublishedDate); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	if m := urlDate.FindStringSubmatch(c.URL); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// PreFilter is a Reranker that narrows large candidate sets down with the
+// Lexical reranker before handing them to a more expensive reranker.
+type PreFilter struct {
+	Filter *Lexical
+	// Size is the number of candidates passed on to Next.
+	Size int
+	Next Reranker
+}
+
+func (r *PreFilter) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	if r.Size <= 0 || len(candidates) <= r.Size {
+		return r.Next.Rerank(ctx, candidates, query, opts)
+	}
+
+	kept := rankByScore(r.Filter.Scores(candidates, query))[:r.Size]
+	// Keep the engine order among the kept candidates.
+	sort.Ints(kept)
+	filtered := make([]Candidate, len(kept))
+	for i, index := range kept {
+		filtered[i] = candidates[index]
+	}
+
+	order, err := r.Next.Rerank(ctx, filtered, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range order {
+		order[i].Index = kept[order[i].Index]
+	}
+	return order, nil
+}
@@ -0,0 +1,180 @@
+package reranker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// reverseReranker ranks the candidates in reverse order and records the
+// candidates it was given.
+type reverseReranker struct {
+	candidates []Candidate
+}
+
+func (r *reverseReranker) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	r.candidates = candidates
+	var ranked []Ranked
+	for i := len(candidates) - 1; i >= 0 && len(ranked) < opts.results(); i-- {
+		ranked = append(ranked, Ranked{Index: i})
+	}
+	return ranked, nil
+}
+
+func TestLexicalRerank(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		trust      map[string]float64
+		want       []int
+	}{
+		{
+			name: "BM25",
+			candidates: []Candidate{
+				{URL: "https://a.example/", Title: "Cooking pasta"},
+				{URL: "https://b.example/", Title: "Go generics tutorial", Snippet: "Go generics and type parameters"},
+				{URL: "https://c.example/", Title: "Go release notes"},
+			},
+			want: []int{1, 2, 0},
+		},
+		{
+			name: "engine agreement",
+			candidates: []Candidate{
+				{URL: "https://a.example/", Title: "Go generics", Engines: []string{"google"}},
+				{URL: "https://b.example/", Title: "Go generics", Engines: []string{"google", "bing", "brave"}},
+				{URL: "https://c.example/", Title: "Go generics", Engines: []string{"bing"}},
+			},
+			want: []int{1, 0, 2},
+		},
+		{
+			name: "recency",
+			candidates: []Candidate{
+				{URL: "https://a.example/", Title: "Go generics", PublishedDate: "2010-01-02"},
+				{URL: "https://b.example/2023/12/go", Title: "Go generics"},
+				{URL: "https://c.example/", Title: "Go generics", PublishedDate: "2024-05-30T12:00:00Z"},
+			},
+			want: []int{2, 1, 0},
+		},
+		{
+			name: "recency without a time zone",
+			candidates: []Candidate{
+				{URL: "https://a.example/", Title: "Go generics", PublishedDate: "2010-01-02T08:00:00"},
+				{URL: "https://b.example/", Title: "Go generics", PublishedDate: "2024-05-30T12:00:00.123456"},
+				{URL: "https://c.example/", Title: "Go generics"},
+			},
+			want: []int{1, 0, 2},
+		},
+		{
+			name: "domain trust matches subdomains",
+			candidates: []Candidate{
+				{URL: "https://spam.example/", Title: "Go generics"},
+				{URL: "https://www.docs.go.dev/generics", Title: "Go generics"},
+				{URL: "https://blog.example/", Title: "Go generics"},
+			},
+			trust: map[string]float64{"spam.example": 0.5, "go.dev": 1.5},
+			want:  []int{1, 2, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Lexical{DomainTrust: tt.trust, Now: func() time.Time { return now }}
+			got, err := r.Rerank(context.Background(), tt.candidates, "go generics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(indexes(got), tt.want) {
+				t.Errorf("Rerank() = %v, want %v", indexes(got), tt.want)
+			}
+			if !got[0].Scored || got[0].Score != 1 {
+				t.Errorf("Rerank() best = %+v, want a score of 1", got[0])
+			}
+			for _, r := range got[1:] {
+				if !r.Scored || r.Score > 1 || r.Score < 0 {
+					t.Errorf("Rerank() = %+v, want a score between 0 and 1", r)
+				}
+			}
+		})
+	}
+}
+
+func TestCandidateDate(t *testing.T) {
+	tests := []struct {
+		date string
+		url  string
+		want time.Time
+		ok   bool
+	}{
+		{date: "2024-05-30T12:00:00Z", want: time.Date(2024, 5, 30, 12, 0, 0, 0, time.UTC), ok: true},
+		{date: "2024-05-30T12:00:00", want: time.Date(2024, 5, 30, 12, 0, 0, 0, time.UTC), ok: true},
+		{date: "2024-05-30 12:00:00", want: time.Date(2024, 5, 30, 12, 0, 0, 0, time.UTC), ok: true},
+		{date: "2024-05-30", want: time.Date(2024, 5, 30, 0, 0, 0, 0, time.UTC), ok: true},
+		{date: "May 30, 2024", want: time.Date(2024, 5, 30, 0, 0, 0, 0, time.UTC), ok: true},
+		{date: "yesterday", url: "https://a.example/2023/12/go", want: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), ok: true},
+		{date: "yesterday", url: "https://a.example/go"},
+	}
+
+	for _, tt := range tests {
+		got, ok := candidateDate(&Candidate{URL: tt.url, PublishedDate: tt.date})
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("candidateDate(%q, %q) = %v, %v, want %v, %v", tt.date, tt.url, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLexicalRerankResults(t *testing.T) {
+	candidates := make([]Candidate, 6)
+	got, err := (&Lexical{}).Rerank(context.Background(), candidates, "go", &Options{Results: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexes(got), []int{0, 1}) {
+		t.Errorf("Rerank() = %v, want the first two candidates", indexes(got))
+	}
+
+	if _, err := (&Lexical{}).Rerank(context.Background(), nil, "go", nil); err != ErrRerankFailed {
+		t.Errorf("Rerank() without candidates error = %v, want ErrRerankFailed", err)
+	}
+}
+
+func TestPreFilter(t *testing.T) {
+	candidates := []Candidate{
+		{URL: "https://a.example/", Title: "Cooking pasta"},
+		{URL: "https://b.example/", Title: "Go generics"},
+		{URL: "https://c.example/", Title: "Gardening"},
+		{URL: "https://d.example/", Title: "Go generics tutorial", Snippet: "Go generics"},
+		{URL: "https://e.example/", Title: "Go release notes"},
+	}
+
+	next := &reverseReranker{}
+	r := &PreFilter{Filter: &Lexical{}, Size: 3, Next: next}
+	got, err := r.Rerank(context.Background(), candidates, "go generics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The three best candidates are passed on in the engine order, and the
+	// ranking refers to the original candidates.
+	var titles []string
+	for _, c := range next.candidates {
+		titles = append(titles, c.Title)
+	}
+	if want := []string{"Go generics", "Go generics tutorial", "Go release notes"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("PreFilter passed %q, want %q", titles, want)
+	}
+	if want := []int{4, 3, 1}; !reflect.DeepEqual(indexes(got), want) {
+		t.Errorf("Rerank() = %v, want %v", indexes(got), want)
+	}
+
+	// Small candidate sets are passed on as they are.
+	next = &reverseReranker{}
+	r = &PreFilter{Filter: &Lexical{}, Size: 10, Next: next}
+	if _, err := r.Rerank(context.Background(), candidates, "go generics", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.candidates) != len(candidates) {
+		t.Errorf("PreFilter passed %d candidates, want all %d", len(next.candidates), len(candidates))
+	}
+}
@@ -0,0 +1,178 @@
+package reranker
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultDiversity is the weight of the domain penalty of the diversity step
+// recommended for general search.
+const DefaultDiversity = 0.3
+
+// diversityPool is how many times more candidates than selected are ranked
+// when the diversity step is enabled, so that it has alternatives to pick.
+const diversityPool = 2
+
+// defaultAvoidFileTypes are avoided when Options.AvoidFileTypes is nil.
+var defaultAvoidFileTypes = []string{"pdf"}
+
+// Options control which and how many candidates are selected.
+type Options struct {
+	// Results is the number of candidates selected (default: DefaultResults).
+	Results int `json:"results,omitempty"`
+
+	// AvoidFileTypes are file extensions ranked last, e.g. "pdf" (default:
+	// pdf; an empty, non-nil list avoids nothing). PreferFileTypes are file
+	// extensions ranked first.
+	AvoidFileTypes  []string `json:"avoid_file_types,omitempty"`
+	PreferFileTypes []string `json:"prefer_file_types,omitempty"`
+
+	// Criteria are additional relevance criteria. Only the prompt-based
+	// reranker reads them.
+	Criteria []string `json:"criteria,omitempty"`
+
+	// Diversity is the weight, between 0 and 1, of the penalty for selecting
+	// several candidates from the same domain (0 disables the diversity step).
+	Diversity float64 `json:"diversity,omitempty"`
+
+	// MinScore drops candidates scored below it (0 keeps all). Candidates
+	// without a score are kept. See Ranked.Score for the scales.
+	MinScore float64 `json:"min_score,omitempty"`
+}
+
+func (o *Options) results() int {
+	if o == nil || o.Results <= 0 {
+		return DefaultResults
+	}
+	return o.Results
+}
+
+func (o *Options) avoidFileTypes() []string {
+	if o == nil || o.AvoidFileTypes == nil {
+		return defaultAvoidFileTypes
+	}
+	return o.AvoidFileTypes
+}
+
+// Select ranks candidates with r and returns the selected candidates, most
+// relevant first, applying the threshold, the file-type preferences and the
+// diversity step of opts.
+func Select(ctx context.Context, r Reranker, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	pool := opts
+	if opts != nil && opts.Diversity > 0 {
+		widened := *opts
+		widened.Results = opts.results() * diversityPool
+		pool = &widened
+	}
+
+	order, err := r.Rerank(ctx, candidates, query, pool)
+	if err != nil {
+		return nil, err
+	}
+	return Finish(candidates, order, opts), nil
+}
+
+// Finish applies the threshold, the file-type preferences and the diversity
+// step of opts to a ranking and cuts it to the number of selected candidates.
+func Finish(candidates []Candidate, order []Ranked, opts *Options) []Ranked {
+	avoid := opts.avoidFileTypes()
+	var prefer []string
+	if opts != nil {
+		prefer = opts.PreferFileTypes
+	}
+
+	if opts != nil && opts.MinScore > 0 {
+		kept := make([]Ranked, 0, len(order))
+		for _, r := range order {
+			if !r.Scored || r.Score >= opts.MinScore {
+				kept = append(kept, r)
+			}
+		}
+		order = kept
+	}
+
+	if len(avoid) > 0 || len(prefer) > 0 {
+		var preferred, neutral, avoided []Ranked
+		for _, r := range order {
+			switch t := fileType(candidates[r.Index].URL); {
+			case t != "" && containsFold(prefer, t):
+				preferred = append(preferred, r)
+			case t != "" && containsFold(avoid, t):
+				avoided = append(avoided, r)
+			default:
+				neutral = append(neutral, r)
+			}
+		}
+		order = append(append(preferred, neutral...), avoided...)
+	}
+
+	if opts != nil && opts.Diversity > 0 {
+		order = Diversify(candidates, order, opts.Diversity, opts.results())
+	}
+
+	if n := opts.results(); len(order) > n {
+		order = order[:n]
+	}
+	return order
+}
+
+// Diversify picks n candidates from a ranking with maximal marginal
+// relevance: the relevance of a candidate decreases linearly with its rank,
+// and it is penalized by weight for every selected candidate from the same
+// domain.
+func Diversify(candidates []Candidate, order []Ranked, weight float64, n int) []Ranked {
+	if n > len(order) {
+		n = len(order)
+	}
+
+	picked := make([]bool, len(order))
+	domains := make(map[string]int)
+	result := make([]Ranked, 0, n)
+	for len(result) < n {
+		best, bestScore := -1, 0.0
+		for rank, r := range order {
+			if picked[rank] {
+				continue
+			}
+			relevance := 1 - float64(rank)/float64(len(order))
+			score := (1-weight)*relevance - weight*float64(domains[domain(candidates[r.Index].URL)])
+			if best < 0 || score > bestScore {
+				best, bestScore = rank, score
+			}
+		}
+		picked[best] = true
+		domains[domain(candidates[order[best].Index].URL)]++
+		result = append(result, order[best])
+	}
+	return result
+}
+
+// domain returns the host of rawURL without a leading www.
+func domain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// fileType returns the lower-case extension of the path of rawURL, without
+// the dot.
+func fileType(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(path.Ext(u.Path), "."))
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimPrefix(v, "."), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package reranker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// fixedReranker returns its order, cut to the requested results, or fails
+// with err, and records the options it was given.
+type fixedReranker struct {
+	order []Ranked
+	err   error
+	opts  *Options
+}
+
+func (r *fixedReranker) Rerank(ctx context.Context, candidates []Candidate, query string, opts *Options) ([]Ranked, error) {
+	r.opts = opts
+	if r.err != nil {
+		return nil, r.err
+	}
+	if n := opts.results(); len(r.order) > n {
+		return r.order[:n], nil
+	}
+	return r.order, nil
+}
+
+// ranking returns an unscored ranking of the given indexes.
+func ranking(indexes ...int) []Ranked {
+	ranked := make([]Ranked, len(indexes))
+	for i, index := range indexes {
+		ranked[i] = Ranked{Index: index}
+	}
+	return ranked
+}
+
+func TestFinishFileTypes(t *testing.T) {
+	candidates := []Candidate{
+		{URL: "https://a.example/paper.pdf"},
+		{URL: "https://b.example/page"},
+		{URL: "https://c.example/index.HTML"},
+		{URL: "https://d.example/slides.pptx?download=1"},
+		{URL: "https://e.example/report.PDF"},
+	}
+	order := ranking(0, 1, 2, 3, 4)
+
+	tests := []struct {
+		name string
+		opts *Options
+		want []int
+	}{
+		{name: "pdf avoided by default", opts: &Options{Results: 5}, want: []int{1, 2, 3, 0, 4}},
+		{name: "nil options", want: []int{1, 2, 3, 0}},
+		{name: "empty avoid list", opts: &Options{Results: 5, AvoidFileTypes: []string{}}, want: []int{0, 1, 2, 3, 4}},
+		{
+			name: "preferred and avoided",
+			opts: &Options{Results: 5, AvoidFileTypes: []string{".pptx"}, PreferFileTypes: []string{"html", "pdf"}},
+			want: []int{0, 2, 4, 1, 3},
+		},
+		{name: "cut after sorting", opts: &Options{Results: 2, PreferFileTypes: []string{"html"}}, want: []int{2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Finish(candidates, order, tt.opts); !reflect.DeepEqual(indexes(got), tt.want) {
+				t.Errorf("Finish() = %v, want %v", indexes(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestFinishMinScore(t *testing.T) {
+	candidates := make([]Candidate, 4)
+	order := []Ranked{
+		{Index: 0, Score: 0.9, Scored: true},
+		{Index: 1},
+		{Index: 2, Score: 0.4, Scored: true},
+		{Index: 3, Score: 0.5, Scored: true},
+	}
+
+	tests := []struct {
+		name     string
+		minScore float64
+		want     []int
+	}{
+		{name: "zero keeps all", minScore: 0, want: []int{0, 1, 2, 3}},
+		{name: "below the threshold dropped, unscored kept", minScore: 0.5, want: []int{0, 1, 3}},
+		{name: "all scored dropped", minScore: 0.95, want: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Finish(candidates, order, &Options{Results: 4, MinScore: tt.minScore})
+			if !reflect.DeepEqual(indexes(got), tt.want) {
+				t.Errorf("Finish() = %v, want %v", indexes(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestDiversify(t *testing.T) {
+	candidates := []Candidate{
+		{URL: "https://a.example/1"},
+		{URL: "https://www.a.example/2"},
+		{URL: "https://a.example/3"},
+		{URL: "https://b.example/1"},
+		{URL: "https://c.example/1"},
+	}
+	order := ranking(0, 1, 2, 3, 4)
+
+	tests := []struct {
+		name   string
+		weight float64
+		n      int
+		want   []int
+	}{
+		{name: "no penalty keeps the ranking", weight: 0, n: 3, want: []int{0, 1, 2}},
+		{name: "other domains first", weight: 0.5, n: 3, want: []int{0, 3, 4}},
+		{name: "repeated domains after the others", weight: 0.5, n: 5, want: []int{0, 3, 4, 1, 2}},
+		{name: "penalty traded against rank", weight: 0.3, n: 3, want: []int{0, 3, 1}},
+		{name: "small penalty", weight: 0.1, n: 3, want: []int{0, 1, 3}},
+		{name: "n above the ranking", weight: 0.5, n: 10, want: []int{0, 3, 4, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diversify(candidates, order, tt.weight, tt.n); !reflect.DeepEqual(indexes(got), tt.want) {
+				t.Errorf("Diversify() = %v, want %v", indexes(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	candidates := []Candidate{
+		{URL: "https://a.example/1"},
+		{URL: "https://a.example/2"},
+		{URL: "https://a.example/3"},
+		{URL: "https://b.example/1"},
+		{URL: "https://c.example/1"},
+	}
+
+	// Without the diversity step the reranker picks the results itself.
+	r := &fixedReranker{order: ranking(0, 1, 2, 3, 4)}
+	got, err := Select(context.Background(), r, candidates, "query", &Options{Results: 2, AvoidFileTypes: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.opts.Results != 2 || !reflect.DeepEqual(indexes(got), []int{0, 1}) {
+		t.Errorf("Select() asked for %d results and returned %v, want 2 and [0 1]", r.opts.Results, indexes(got))
+	}
+
+	// With it, the reranker ranks a wider pool for it to pick from.
+	r = &fixedReranker{order: ranking(0, 1, 2, 3, 4)}
+	got, err = Select(context.Background(), r, candidates, "query", &Options{Results: 2, Diversity: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.opts.Results != 2*diversityPool || !reflect.DeepEqual(indexes(got), []int{0, 3}) {
+		t.Errorf("Select() asked for %d results and returned %v, want %d and [0 3]", r.opts.Results, indexes(got), 2*diversityPool)
+	}
+}
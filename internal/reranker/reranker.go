@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/lemon-mint/coord/llm"
 	"github.com/lemon-mint/coord/llmtools"
@@ -14,9 +15,11 @@ import (
 
 const prompt = `You are a search re-ranker. Given a user's query and a description of what information should be extracted, rank the candidate webpages from most to least relevant. 
 
-Each candidate webpage will include its URL, title, and snippet. Analyze these and return a JSON object containing an array of webpage indices, ordered from most to least relevant to the query, in the following format:
+Each candidate webpage will include its URL, title, and snippet. Analyze these and return a JSON array of the selected webpages, ordered from most to least relevant to the query, in the following format:
 
-<reranking_result>[14, 3, 2, 5, ...]</reranking_result>
+<reranking_result>[{"index": 14, "score": 0.95, "reason": "Official documentation of the feature"}, {"index": 3, "score": 0.7, "reason": "..."}, ...]</reranking_result>
+
+"index" is the index of the webpage, "score" its relevance to the query from 0 (irrelevant) to 1 (exactly the information needed), and "reason" one short sentence explaining the choice.
 
 Consider these criteria when determining webpage relevance:
 
@@ -26,14 +29,40 @@ Consider these criteria when determining webpage relevance:
 4. **Keyword Relevance:**  Consider if the webpage's title or snippet contains keywords from the query.
 5. **Avoiding Duplicates:**  Avoid selecting the same webpage multiple times.
 6. **Contextual Relevance:**  Consider the overall context of the query and the relevance of the webpage to the query.
-7. **Avoid PDF Files:**  Avoid selecting PDF files.
-
-Select up to 4 websites, even if they overlap thematically, as long as they provide distinct information.`
+{{CRITERIA}}
+Select up to {{RESULTS}} websites, even if they overlap thematically, as long as they provide distinct information.`
 
 var ErrRerankFailed = errors.New("rerank failed")
 var re = regexp.MustCompile(`<reranking_result>((.|\n)*?)</reranking_result>`)
 
-func RerankDocuments(ctx context.Context, m llm.Model, documents []string, query string) ([]int, error) {
+// criteriaPrompt renders the criteria of opts that come after the built-in
+// ones of the prompt.
+func criteriaPrompt(opts *Options) string {
+	var sb strings.Builder
+	n := 7
+	if types := opts.avoidFileTypes(); len(types) > 0 {
+		files := strings.ToUpper(strings.Join(types, ", "))
+		sb.WriteString(strconv.Itoa(n) + ". **Avoid " + files + " Files:**  Avoid selecting " + files + " files.\n")
+		n++
+	}
+	if opts == nil {
+		return sb.String()
+	}
+	if len(opts.PreferFileTypes) > 0 {
+		files := strings.ToUpper(strings.Join(opts.PreferFileTypes, ", "))
+		sb.WriteString(strconv.Itoa(n) + ". **Prefer " + files + " Files:**  Favor " + files + " files when they are relevant.\n")
+		n++
+	}
+	for _, criterion := range opts.Criteria {
+		if criterion = strings.TrimSpace(criterion); criterion != "" {
+			sb.WriteString(strconv.Itoa(n) + ". " + criterion + "\n")
+			n++
+		}
+	}
+	return sb.String()
+}
+
+func RerankDocuments(ctx context.Context, m llm.Model, documents []string, query string, opts *Options) ([]Ranked, error) {
 	var sb strings.Builder
 
 	if len(documents) == 0 {
@@ -59,7 +88,7 @@ func RerankDocuments(ctx context.Context, m llm.Model, documents []string, query
 	stream := m.GenerateStream(ctx,
 		&llm.ChatContext{
 			Contents: []*llm.Content{
-				llm.TextContent(llm.RoleUser, prompt),
+				llm.TextContent(llm.RoleUser, strings.NewReplacer("{{CRITERIA}}", criteriaPrompt(opts), "{{RESULTS}}", strconv.Itoa(opts.results())).Replace(prompt)),
 				llm.TextContent(llm.RoleModel, "Sure, Please provide the candidate webpages for me to rank."),
 			},
 		},
@@ -71,29 +100,124 @@ func RerankDocuments(ctx context.Context, m llm.Model, documents []string, query
 		return nil, err
 	}
 
-	text := llmtools.TextFromContents(stream.Content)
-	var result []int
+	return parseResult(llmtools.TextFromContents(stream.Content), len(documents))
+}
 
-	matches := re.FindStringSubmatch(text)
-	if len(matches) < 2 {
+var arrayRe = regexp.MustCompile(`\[[\d\s,"]*\]`)
+var objectArrayRe = regexp.MustCompile(`(?s)\[\s*\{.*\}\s*,?\s*\]`)
+var objectRe = regexp.MustCompile(`\{[^{}]*\}`)
+var intRe = regexp.MustCompile(`\d+`)
+var trailingCommaRe = regexp.MustCompile(`,\s*([}\]])`)
+
+// maxRationale is the length in bytes rationales are cut to.
+const maxRationale = 300
+
+// parseResult extracts the ranking from the reply of the model. The reply is
+// read leniently: without a <reranking_result> tag the last JSON array is
+// used, entries may be bare indexes instead of objects, and entries that are
+// not valid candidate indexes or that are repeated are skipped.
+// ErrRerankFailed is only returned if no valid index is left.
+func parseResult(text string, n int) ([]Ranked, error) {
+	var list string
+	if matches := re.FindStringSubmatch(text); len(matches) >= 2 {
+		list = matches[1]
+	} else if objects := objectArrayRe.FindString(text); objects != "" {
+		list = objects
+	} else if arrays := arrayRe.FindAllString(text, -1); len(arrays) > 0 {
+		list = arrays[len(arrays)-1]
+	} else {
 		return nil, ErrRerankFailed
 	}
 
-	jsonStr := strings.TrimSpace(matches[1])
-	err = json.Unmarshal([]byte(jsonStr), &result)
+	var entries []any
+	err := json.Unmarshal([]byte(strings.TrimSpace(list)), &entries)
 	if err != nil {
-		return nil, ErrRerankFailed
+		err = json.Unmarshal([]byte(trailingCommaRe.ReplaceAllString(list, "$1")), &entries)
+	}
+	if err != nil {
+		// Not JSON: read the objects one by one, or take the numbers as they
+		// come.
+		entries = nil
+		if objects := objectRe.FindAllString(list, -1); len(objects) > 0 {
+			for _, s := range objects {
+				var object map[string]any
+				if json.Unmarshal([]byte(s), &object) == nil {
+					entries = append(entries, object)
+				}
+			}
+		} else {
+			for _, s := range intRe.FindAllString(list, -1) {
+				entries = append(entries, s)
+			}
+		}
+	}
+
+	var result []Ranked
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		var r Ranked
+		var ok bool
+		if object, isObject := entry.(map[string]any); isObject {
+			r.Index, ok = parseIndex(object["index"])
+			if score, scored := parseScore(object["score"]); scored {
+				r.Score, r.Scored = min(max(score, 0), 1), true
+			}
+			for _, key := range []string{"reason", "rationale"} {
+				if reason, isString := object[key].(string); isString {
+					r.Rationale = truncate(strings.TrimSpace(reason), maxRationale)
+					break
+				}
+			}
+		} else {
+			r.Index, ok = parseIndex(entry)
+		}
+		if !ok || r.Index < 0 || r.Index >= n || seen[r.Index] {
+			continue
+		}
+		seen[r.Index] = true
+		result = append(result, r)
 	}
 
 	if len(result) == 0 {
 		return nil, ErrRerankFailed
 	}
+	return result, nil
+}
 
-	for _, idx := range result {
-		if idx < 0 || idx >= len(documents) {
-			return nil, ErrRerankFailed
+// parseIndex reads a JSON number or numeric string as an index.
+func parseIndex(v any) (int, bool) {
+	switch v := v.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return 0, false
 		}
+		return int(v), true
+	case string:
+		idx, err := strconv.Atoi(strings.TrimSpace(v))
+		return idx, err == nil
 	}
+	return 0, false
+}
 
-	return result, nil
+// parseScore reads a JSON number or numeric string as a score.
+func parseScore(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		score, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return score, err == nil
+	}
+	return 0, false
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
@@ -0,0 +1,210 @@
+package reranker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+)
+
+// replyModel answers every request with the same text, or fails with err,
+// and records the last input it was given.
+type replyModel struct {
+	text  string
+	err   error
+	input string
+}
+
+func (m *replyModel) GenerateStream(ctx context.Context, chat *llm.ChatContext, input *llm.Content) *llm.StreamContent {
+	var sb strings.Builder
+	for _, c := range append(chat.Contents, input) {
+		for _, part := range c.Parts {
+			if text, ok := part.(llm.Text); ok {
+				sb.WriteString(string(text))
+			}
+		}
+	}
+	m.input = sb.String()
+
+	stream := make(chan llm.Segment)
+	close(stream)
+	if m.err != nil {
+		return &llm.StreamContent{Err: m.err, Stream: stream}
+	}
+	return &llm.StreamContent{Content: llm.TextContent(llm.RoleModel, m.text), Stream: stream}
+}
+
+func (m *replyModel) Close() error { return nil }
+
+func (m *replyModel) Name() string { return "reply" }
+
+// indexes returns the candidate indexes of a ranking.
+func indexes(ranked []Ranked) []int {
+	result := make([]int, len(ranked))
+	for i, r := range ranked {
+		result[i] = r.Index
+	}
+	return result
+}
+
+func TestParseResultIndexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []int
+		wantErr bool
+	}{
+		{name: "tagged objects", text: `<reranking_result>[{"index": 2}, {"index": 0}]</reranking_result>`, want: []int{2, 0}},
+		{name: "tagged bare indexes", text: "<reranking_result>\n[3, 1, 2]\n</reranking_result>", want: []int{3, 1, 2}},
+		{name: "string indexes", text: `<reranking_result>["1", " 0 ", {"index": "2"}]</reranking_result>`, want: []int{1, 0, 2}},
+		{name: "untagged objects", text: "Here you go:\n```json\n[{\"index\": 1}, {\"index\": 3}]\n```", want: []int{1, 3}},
+		{name: "last untagged array", text: "Indexes are 0-based, e.g. [0, 1]. Result: [2, 3]", want: []int{2, 3}},
+		{name: "trailing commas", text: `<reranking_result>[{"index": 1,}, {"index": 0},]</reranking_result>`, want: []int{1, 0}},
+		{name: "invalid JSON read object by object", text: `<reranking_result>[{"index": 1}, {"index": 2 "reason": x}, {"index": 0}]</reranking_result>`, want: []int{1, 0}},
+		{name: "invalid JSON read number by number", text: "<reranking_result>[1, 2 3; 0]</reranking_result>", want: []int{1, 2, 3, 0}},
+		{name: "out of range and duplicates skipped", text: `<reranking_result>[4, 1, -1, 1, 1.5, 0, 9]</reranking_result>`, want: []int{1, 0}},
+		{name: "nothing valid", text: `<reranking_result>[7, 8]</reranking_result>`, wantErr: true},
+		{name: "no list", text: "I cannot rank these pages.", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResult(tt.text, 4)
+			if tt.wantErr {
+				if !errors.Is(err, ErrRerankFailed) {
+					t.Fatalf("parseResult() = %+v, %v, want ErrRerankFailed", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseResult() error = %v", err)
+			}
+			if !reflect.DeepEqual(indexes(got), tt.want) {
+				t.Errorf("parseResult() = %v, want %v", indexes(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResultScores(t *testing.T) {
+	long := strings.Repeat("é", maxRationale) // 2 bytes per rune
+
+	tests := []struct {
+		name string
+		text string
+		want []Ranked
+	}{
+		{
+			name: "scores and reasons",
+			text: `<reranking_result>[{"index": 1, "score": 0.9, "reason": " Official docs "}, {"index": 0, "score": 0.25}]</reranking_result>`,
+			want: []Ranked{{Index: 1, Score: 0.9, Scored: true, Rationale: "Official docs"}, {Index: 0, Score: 0.25, Scored: true}},
+		},
+		{
+			name: "scores clamped",
+			text: `<reranking_result>[{"index": 0, "score": 7}, {"index": 1, "score": -0.5}]</reranking_result>`,
+			want: []Ranked{{Index: 0, Score: 1, Scored: true}, {Index: 1, Score: 0, Scored: true}},
+		},
+		{
+			name: "string scores and rationale key",
+			text: `<reranking_result>[{"index": 0, "score": "0.5", "rationale": "why"}]</reranking_result>`,
+			want: []Ranked{{Index: 0, Score: 0.5, Scored: true, Rationale: "why"}},
+		},
+		{
+			name: "invalid scores are unscored",
+			text: `<reranking_result>[{"index": 0, "score": "high"}, {"index": 1, "score": null}]</reranking_result>`,
+			want: []Ranked{{Index: 0}, {Index: 1}},
+		},
+		{
+			name: "bare indexes are unscored",
+			text: `<reranking_result>[1, 0]</reranking_result>`,
+			want: []Ranked{{Index: 1}, {Index: 0}},
+		},
+		{
+			name: "rationale truncated at a rune boundary",
+			text: `<reranking_result>[{"index": 0, "reason": "x` + long + `"}]</reranking_result>`,
+			want: []Ranked{{Index: 0, Rationale: "x" + long[:maxRationale-2]}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResult(tt.text, 2)
+			if err != nil {
+				t.Fatalf("parseResult() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRerankDocuments(t *testing.T) {
+	m := &replyModel{text: "<reranking_result>[1, 0]</reranking_result>"}
+	got, err := RerankDocuments(context.Background(), m, []string{"doc a", "doc b"}, "query", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexes(got), []int{1, 0}) {
+		t.Errorf("RerankDocuments() = %v, want [1 0]", indexes(got))
+	}
+	for _, want := range []string{"<index>1</index>", "doc b", "Select up to 4 websites", "Avoid PDF Files"} {
+		if !strings.Contains(m.input, want) {
+			t.Errorf("RerankDocuments() prompt does not contain %q", want)
+		}
+	}
+
+	errModel := errors.New("unavailable")
+	if _, err := RerankDocuments(context.Background(), &replyModel{err: errModel}, []string{"doc"}, "query", nil); !errors.Is(err, errModel) {
+		t.Errorf("RerankDocuments() error = %v, want %v", err, errModel)
+	}
+	if _, err := RerankDocuments(context.Background(), m, nil, "query", nil); !errors.Is(err, ErrRerankFailed) {
+		t.Errorf("RerankDocuments() without documents error = %v, want ErrRerankFailed", err)
+	}
+}
+
+func TestFallbackOrder(t *testing.T) {
+	candidates := []Candidate{
+		{URL: "https://a.example/", Title: "Weather tomorrow"},
+		{URL: "https://b.example/", Title: "Go release notes", Snippet: "What is new in Go generics"},
+		{URL: "https://c.example/", Title: "Go generics tutorial", Snippet: "Learn Go generics and type parameters"},
+		{URL: "https://d.example/", Title: "Cooking pasta"},
+		{URL: "https://e.example/", Title: "Gardening"},
+	}
+
+	tests := []struct {
+		name  string
+		mode  string
+		trust map[string]float64
+		limit int
+		want  []int
+	}{
+		{name: "engine order", mode: FallbackEngine, limit: 3, want: []int{0, 1, 2}},
+		{name: "lexical order", mode: FallbackLexical, limit: 3, want: []int{2, 1, 0}},
+		{name: "lexical is the default", limit: 2, want: []int{2, 1}},
+		{name: "domain trust", trust: map[string]float64{"c.example": 0.1}, limit: 2, want: []int{1, 2}},
+		{name: "default limit", mode: FallbackEngine, want: []int{0, 1, 2, 3}},
+		{name: "limit above the candidates", mode: FallbackEngine, limit: 10, want: []int{0, 1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FallbackOrder(candidates, "go generics", tt.mode, tt.trust, tt.limit)
+			if !reflect.DeepEqual(indexes(got), tt.want) {
+				t.Errorf("FallbackOrder() = %v, want %v", indexes(got), tt.want)
+			}
+			for _, r := range got {
+				if r.Scored {
+					t.Errorf("FallbackOrder() returned a score: %+v", r)
+				}
+			}
+		})
+	}
+
+	if got := FallbackOrder(nil, "go", FallbackLexical, nil, 0); len(got) != 0 {
+		t.Errorf("FallbackOrder() without candidates = %v, want none", got)
+	}
+}
@@ -0,0 +1,149 @@
+package research
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/chat"
+	yaml "gopkg.in/yaml.v3"
+)
+
+type Outline struct {
+	Title    string    `yaml:"title" json:"title"`
+	Sections []Section `yaml:"sections" json:"sections"`
+}
+
+type Section struct {
+	Heading     string `yaml:"heading" json:"heading"`
+	Description string `yaml:"description" json:"description"`
+}
+
+const outlinePrompt = `You are planning a research report. Current time is {{CURRENT_TIME}}.
+
+The research question is:
+<user_query>
+{{USER_QUERY}}
+</user_query>
+
+The research notes:
+<notes>
+{{NOTES}}
+</notes>
+
+Write the outline of a thorough report answering the research question from the notes, with at most {{MAX_SECTIONS}} sections. Start with a summary section and end with a conclusion. Every section must be supported by the notes. Write the title and headings in the language of the research question.
+
+Provide your output in YAML format, structured as follows:
+
+` + "```yaml\n" + `
+title: "(report title)"
+sections:
+- heading: "(section heading)"
+  description: "(what the section covers)"
+` + "```"
+
+const sectionPrompt = `You are writing one section of a research report. Current time is {{CURRENT_TIME}}.
+
+The research question is:
+<user_query>
+{{USER_QUERY}}
+</user_query>
+
+The outline of the report:
+<outline>
+{{OUTLINE}}
+</outline>
+
+The research notes:
+<notes>
+{{NOTES}}
+</notes>
+
+Write the section "{{HEADING}}": {{DESCRIPTION}}
+
+* Only cover the topic of this section; the other sections are written separately.
+* Base the section on the notes and on the documents that follow this message. Do not add information from elsewhere.
+* Cite the documents with their index after every fact, in the format "fact§[<document index>]". Citations of the notes use the same indexes.
+* Write in Markdown, in the language of the research question. Use "###" and lower for sub-headings, tables and lists where they help.
+* Do not repeat the section heading; start directly with the content.`
+
+// GenerateOutline asks the model for the outline of the report.
+func GenerateOutline(ctx context.Context, m llm.Model, query, notes string, maxSections int) (*Outline, error) {
+	if maxSections <= 0 {
+		maxSections = DefaultMaxSections
+	}
+
+	prompt := strings.ReplaceAll(outlinePrompt, "{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z))
+	prompt = strings.ReplaceAll(prompt, "{{USER_QUERY}}", query)
+	prompt = strings.ReplaceAll(prompt, "{{NOTES}}", notes)
+	prompt = strings.ReplaceAll(prompt, "{{MAX_SECTIONS}}", fmt.Sprint(maxSections))
+
+	text, err := generateText(ctx, m, []llm.Segment{llm.Text(prompt)})
+	if err != nil {
+		return nil, err
+	}
+
+	var outline Outline
+	err = yaml.Unmarshal([]byte(stripFence(text, "```yaml\n", "```yml\n", "```\n")), &outline)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outline: %w", err)
+	}
+
+	var sections []Section
+	for _, section := range outline.Sections {
+		section.Heading = strings.TrimSpace(section.Heading)
+		if section.Heading != "" {
+			sections = append(sections, section)
+		}
+	}
+	if len(sections) == 0 {
+		return nil, errors.New("invalid outline: no sections")
+	}
+	if len(sections) > maxSections {
+		sections = sections[:maxSections]
+	}
+	outline.Sections = sections
+	outline.Title = strings.TrimSpace(outline.Title)
+	if outline.Title == "" {
+		outline.Title = query
+	}
+
+	return &outline, nil
+}
+
+// FallbackOutline is the outline used when the model fails to provide one:
+// a single section answering the question.
+func FallbackOutline(query string) *Outline {
+	return &Outline{
+		Title: query,
+		Sections: []Section{{
+			Heading:     "Report",
+			Description: "Answer the research question thoroughly.",
+		}},
+	}
+}
+
+// WriteSection streams the contents of the section of the outline with the
+// given index. indexes are the numbers the documents are cited with.
+func WriteSection(ctx context.Context, m llm.Model, query string, outline *Outline, section int, notes string, documents []chat.Document, indexes []int) *llm.StreamContent {
+	var sb strings.Builder
+	for i, s := range outline.Sections {
+		fmt.Fprintf(&sb, "%d. %s: %s\n", i+1, s.Heading, s.Description)
+	}
+
+	prompt := strings.ReplaceAll(sectionPrompt, "{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z))
+	prompt = strings.ReplaceAll(prompt, "{{USER_QUERY}}", query)
+	prompt = strings.ReplaceAll(prompt, "{{OUTLINE}}", strings.TrimSpace(sb.String()))
+	prompt = strings.ReplaceAll(prompt, "{{NOTES}}", notes)
+	prompt = strings.ReplaceAll(prompt, "{{HEADING}}", outline.Sections[section].Heading)
+	prompt = strings.ReplaceAll(prompt, "{{DESCRIPTION}}", outline.Sections[section].Description)
+
+	parts := append([]llm.Segment{llm.Text(prompt + "\n\n")}, chat.DocumentParts(documents, indexes)...)
+	return m.GenerateStream(ctx, &llm.ChatContext{}, &llm.Content{
+		Role:  llm.RoleUser,
+		Parts: parts,
+	})
+}
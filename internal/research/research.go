@@ -0,0 +1,212 @@
+package research
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/coord/llmtools"
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultMaxRounds is the default number of plan-search-read rounds.
+	DefaultMaxRounds = 3
+	// DefaultMaxPages is the default number of pages crawled over all rounds.
+	DefaultMaxPages = 30
+	// DefaultRoundTokenBudget is the default number of tokens of passages
+	// read per round.
+	DefaultRoundTokenBudget = 32000
+	// DefaultNotesTokens is the default size the notes are kept under.
+	DefaultNotesTokens = 8000
+	// DefaultSectionTokenBudget is the default number of tokens of passages
+	// given to the writer of every report section.
+	DefaultSectionTokenBudget = 16000
+	// DefaultMaxSections is the default number of sections of a report.
+	DefaultMaxSections = 6
+	// DefaultQueriesPerRound is the default number of search queries of a
+	// follow-up round.
+	DefaultQueriesPerRound = 4
+)
+
+const notesPrompt = `You are a research assistant keeping notes for a research report. Current time is {{CURRENT_TIME}}.
+
+The research question is:
+<user_query>
+{{USER_QUERY}}
+</user_query>
+
+Your notes so far:
+<notes>
+{{NOTES}}
+</notes>
+
+New documents were read; they follow this message. Update the notes with everything in the new documents that is relevant to the research question:
+
+* Keep the notes organized by topic, in Markdown, in the language of the research question.
+* Record facts, figures, dates, names, opinions and disagreements between sources. Do not add anything that is not in the documents or the notes.
+* Cite the documents every fact comes from with their index, in the format "fact§[<document index>]". Keep the citations of the existing notes unchanged.
+* Merge duplicate facts, keeping all their citations.
+* Keep the notes under about {{NOTES_TOKENS}} tokens; drop the least relevant details first.
+* End the notes with a "## Open questions" section listing what is still unknown or uncertain.
+
+Reply with the complete updated notes only.`
+
+const roundPrompt = `You are directing a web research. Current time is {{CURRENT_TIME}}.
+
+The research question is:
+<user_query>
+{{USER_QUERY}}
+</user_query>
+
+The search queries run so far:
+<done_queries>
+{{DONE_QUERIES}}
+</done_queries>
+
+The research notes so far:
+<notes>
+{{NOTES}}
+</notes>
+
+1. Decide whether the notes cover the research question well enough for a thorough report. Consider the open questions, missing perspectives, unverified claims and outdated information.
+
+2. If they do not, write at most {{MAX_QUERIES}} new search queries that fill the gaps. Do not repeat the search queries run so far. Generate search keywords in English, and also in the language of the research question for local information.
+
+3. Provide your output in YAML format, structured as follows:
+
+` + "```yaml\n" + `
+done: (true or false)
+reason: "(short explanation of the decision)"
+search_queries: # leave empty if done
+- query: "(search query)"
+  description: "(description of information to extract)"
+` + "```"
+
+// Round is the decision of the planner after a research round.
+type Round struct {
+	Done          bool                      `yaml:"done" json:"done"`
+	Reason        string                    `yaml:"reason" json:"reason"`
+	SearchQueries []queryplan.SearchQueries `yaml:"search_queries" json:"search_queries"`
+}
+
+// stripFence returns the contents of the first fenced block of text, or text
+// itself if it has none.
+func stripFence(text string, fences ...string) string {
+	text = strings.TrimSpace(text)
+	for _, fence := range fences {
+		if _, after, ok := strings.Cut(text, fence); ok {
+			text, _, _ = strings.Cut(after, "```")
+			break
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// generateText runs the model on a single user message and returns the text
+// of its reply.
+func generateText(ctx context.Context, m llm.Model, parts []llm.Segment) (string, error) {
+	stream := m.GenerateStream(ctx, &llm.ChatContext{}, &llm.Content{
+		Role:  llm.RoleUser,
+		Parts: parts,
+	})
+	err := stream.Wait()
+	if err != nil {
+		return "", err
+	}
+	return llmtools.TextFromContents(stream.Content), nil
+}
+
+// TakeNotes reads documents and returns the notes updated with what they
+// say about the research question. indexes are the numbers the documents are
+// cited with.
+func TakeNotes(ctx context.Context, m llm.Model, query, notes string, documents []chat.Document, indexes []int, notesTokens int) (string, error) {
+	if notesTokens <= 0 {
+		notesTokens = DefaultNotesTokens
+	}
+	current := notes
+	if strings.TrimSpace(current) == "" {
+		current = "(no notes yet)"
+	}
+
+	prompt := strings.ReplaceAll(notesPrompt, "{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z))
+	prompt = strings.ReplaceAll(prompt, "{{USER_QUERY}}", query)
+	prompt = strings.ReplaceAll(prompt, "{{NOTES}}", current)
+	prompt = strings.ReplaceAll(prompt, "{{NOTES_TOKENS}}", fmt.Sprint(notesTokens))
+
+	parts := append([]llm.Segment{llm.Text(prompt + "\n\n")}, chat.DocumentParts(documents, indexes)...)
+	text, err := generateText(ctx, m, parts)
+	if err != nil {
+		return notes, err
+	}
+
+	text = stripFence(text, "```markdown\n", "```md\n")
+	if text == "" {
+		return notes, errors.New("empty notes")
+	}
+	return text, nil
+}
+
+// NextRound asks the model whether the notes are sufficient for the report
+// and, if not, for the search queries of the next round.
+func NextRound(ctx context.Context, m llm.Model, query, notes string, done []queryplan.SearchQueries, maxQueries int) (*Round, error) {
+	if maxQueries <= 0 {
+		maxQueries = DefaultQueriesPerRound
+	}
+
+	var doneQueries strings.Builder
+	for _, q := range done {
+		fmt.Fprintf(&doneQueries, "- %q\n", q.Query)
+	}
+
+	prompt := strings.ReplaceAll(roundPrompt, "{{CURRENT_TIME}}", time.Now().Format(time.RFC1123Z))
+	prompt = strings.ReplaceAll(prompt, "{{USER_QUERY}}", query)
+	prompt = strings.ReplaceAll(prompt, "{{DONE_QUERIES}}", strings.TrimSpace(doneQueries.String()))
+	prompt = strings.ReplaceAll(prompt, "{{NOTES}}", notes)
+	prompt = strings.ReplaceAll(prompt, "{{MAX_QUERIES}}", fmt.Sprint(maxQueries))
+
+	text, err := generateText(ctx, m, []llm.Segment{llm.Text(prompt)})
+	if err != nil {
+		return nil, err
+	}
+
+	var round Round
+	err = yaml.Unmarshal([]byte(stripFence(text, "```yaml\n", "```yml\n", "```\n")), &round)
+	if err != nil {
+		return nil, fmt.Errorf("invalid research round: %w", err)
+	}
+	if round.Done {
+		round.SearchQueries = nil
+		return &round, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, q := range done {
+		seen[strings.ToLower(q.Query)] = true
+	}
+	var queries []queryplan.SearchQueries
+	for _, q := range round.SearchQueries {
+		q.Query = strings.TrimSpace(q.Query)
+		q.DependsOn = nil
+		if q.Query == "" || seen[strings.ToLower(q.Query)] {
+			continue
+		}
+		seen[strings.ToLower(q.Query)] = true
+		queries = append(queries, q)
+	}
+	if len(queries) > maxQueries {
+		queries = queries[:maxQueries]
+	}
+	if len(queries) == 0 {
+		// Nothing new to look for.
+		round.Done = true
+	}
+	round.SearchQueries = queries
+
+	return &round, nil
+}
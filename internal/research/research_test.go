@@ -0,0 +1,172 @@
+package research
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+)
+
+// replyModel answers every request with the same text, or fails with err.
+type replyModel struct {
+	text string
+	err  error
+}
+
+func (m *replyModel) GenerateStream(ctx context.Context, chat *llm.ChatContext, input *llm.Content) *llm.StreamContent {
+	stream := make(chan llm.Segment)
+	close(stream)
+	if m.err != nil {
+		return &llm.StreamContent{Err: m.err, Stream: stream}
+	}
+	return &llm.StreamContent{Content: llm.TextContent(llm.RoleModel, m.text), Stream: stream}
+}
+
+func (m *replyModel) Close() error { return nil }
+
+func (m *replyModel) Name() string { return "reply" }
+
+func TestNextRound(t *testing.T) {
+	done := []queryplan.SearchQueries{{Query: "Go generics"}, {Query: "go 1.18 release notes"}}
+
+	tests := []struct {
+		name       string
+		reply      string
+		maxQueries int
+		want       *Round
+		wantErr    bool
+	}{
+		{
+			name:  "new queries",
+			reply: "```yaml\ndone: false\nreason: missing benchmarks\nsearch_queries:\n  - query: go generics performance\n    description: benchmarks\n```",
+			want: &Round{Reason: "missing benchmarks", SearchQueries: []queryplan.SearchQueries{
+				{Query: "go generics performance", Description: "benchmarks"},
+			}},
+		},
+		{
+			name: "done queries and duplicates are dropped",
+			reply: "search_queries:\n" +
+				"  - query: GO GENERICS\n" +
+				"  - query: ' go generics performance '\n" +
+				"  - query: Go Generics Performance\n" +
+				"  - query: ''\n" +
+				"  - query: type parameters proposal\n" +
+				"    depends_on: [0]\n",
+			want: &Round{SearchQueries: []queryplan.SearchQueries{
+				{Query: "go generics performance"},
+				{Query: "type parameters proposal"},
+			}},
+		},
+		{
+			name:       "capped at maxQueries",
+			reply:      "search_queries:\n  - query: a\n  - query: b\n  - query: c\n",
+			maxQueries: 2,
+			want:       &Round{SearchQueries: []queryplan.SearchQueries{{Query: "a"}, {Query: "b"}}},
+		},
+		{
+			name:  "nothing new ends the research",
+			reply: "search_queries:\n  - query: go generics\n  - query: Go 1.18 Release Notes\n",
+			want:  &Round{Done: true},
+		},
+		{
+			name:  "done drops the queries",
+			reply: "done: true\nreason: enough\nsearch_queries:\n  - query: more\n",
+			want:  &Round{Done: true, Reason: "enough"},
+		},
+		{
+			name:    "invalid YAML",
+			reply:   "search_queries: [",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextRound(context.Background(), &replyModel{text: tt.reply}, "query", "notes", done, tt.maxQueries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NextRound() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NextRound() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextRoundModelError(t *testing.T) {
+	errModel := errors.New("unavailable")
+	_, err := NextRound(context.Background(), &replyModel{err: errModel}, "query", "notes", nil, 0)
+	if !errors.Is(err, errModel) {
+		t.Errorf("NextRound() error = %v, want %v", err, errModel)
+	}
+}
+
+func TestTakeNotes(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    string
+		wantErr bool
+	}{
+		{name: "fenced notes", reply: "```markdown\n- fact §[1]\n```", want: "- fact §[1]"},
+		{name: "plain notes", reply: "- fact §[1]\n", want: "- fact §[1]"},
+		{name: "empty notes keep the old ones", reply: "```md\n```", want: "old notes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TakeNotes(context.Background(), &replyModel{text: tt.reply}, "query", "old notes", nil, nil, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TakeNotes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TakeNotes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateOutline(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       string
+		maxSections int
+		want        *Outline
+		wantErr     bool
+	}{
+		{
+			name:  "outline",
+			reply: "```yaml\ntitle: ' Go generics '\nsections:\n  - heading: Background\n    description: history\n  - heading: ' '\n  - heading: Performance\n```",
+			want: &Outline{Title: "Go generics", Sections: []Section{
+				{Heading: "Background", Description: "history"},
+				{Heading: "Performance"},
+			}},
+		},
+		{
+			name:        "title defaults to the query and sections are capped",
+			reply:       "sections:\n  - heading: A\n  - heading: B\n  - heading: C\n",
+			maxSections: 2,
+			want:        &Outline{Title: "query", Sections: []Section{{Heading: "A"}, {Heading: "B"}}},
+		},
+		{
+			name:    "no sections",
+			reply:   "title: T\nsections: []\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GenerateOutline(context.Background(), &replyModel{text: tt.reply}, "query", "notes", tt.maxSections)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateOutline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GenerateOutline() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -16,6 +16,13 @@ type SearchResult struct {
 	URL     string   `json:"url"`
 	Content string   `json:"content"`
 	Engines []string `json:"engines"`
+
+	PublishedDate string `json:"published_date,omitempty"` // as shown by SearXNG, if known
+
+	// Set on reranked results: the relevance score, if the reranker gave one,
+	// and why the result was selected.
+	Score     *float64 `json:"score,omitempty"`
+	Rationale string   `json:"rationale,omitempty"`
 }
 
 func SearchSearXNG(client *http.Client, endpoint, keyword string, engines []string) ([]SearchResult, error) {
@@ -82,6 +89,12 @@ func SearchSearXNG(client *http.Client, endpoint, keyword string, engines []stri
 
 		content := strings.TrimSpace(s.Find("p.content").Text())
 
+		publishedDate, ok := s.Find("time.published_date").Attr("datetime")
+		if !ok {
+			publishedDate = s.Find("time.published_date").Text()
+		}
+		publishedDate = strings.TrimSpace(publishedDate)
+
 		var engines []string
 		s.Find("div.engines > span").Each(func(i int, s *goquery.Selection) {
 			engines = append(engines, strings.TrimSpace(s.Text()))
@@ -92,6 +105,8 @@ func SearchSearXNG(client *http.Client, endpoint, keyword string, engines []stri
 			URL:     url,
 			Content: content,
 			Engines: engines,
+
+			PublishedDate: publishedDate,
 		})
 	})
 
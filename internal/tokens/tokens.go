@@ -0,0 +1,81 @@
+package tokens
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lemon-mint/coord/llm"
+)
+
+// ImageTokens is the approximate cost of a single inline image.
+const ImageTokens = 768
+
+// Estimate returns an approximation of the number of tokens in s.
+//
+// The estimate does not depend on a specific tokenizer: ASCII text is counted
+// as roughly four characters per token, while other scripts (CJK, Hangul, ...)
+// are counted as one token per rune, which is close to what the major
+// providers report for those languages.
+func Estimate(s string) int {
+	var ascii, other int
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else if !unicode.IsSpace(r) {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// EstimateSegments returns an approximation of the number of tokens in parts.
+func EstimateSegments(parts []llm.Segment) int {
+	var n int
+	for _, part := range parts {
+		switch part := part.(type) {
+		case llm.Text:
+			n += Estimate(string(part))
+		case *llm.InlineData, *llm.FileData:
+			n += ImageTokens
+		}
+	}
+	return n
+}
+
+// Truncate cuts s so that it holds at most about limit tokens. It prefers to
+// cut at a paragraph or line boundary when one is close to the limit.
+func Truncate(s string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	if Estimate(s) <= limit {
+		return s
+	}
+
+	var count, ascii int
+	end := len(s)
+	for i, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+			if ascii%4 == 1 {
+				count++
+			}
+		} else if !unicode.IsSpace(r) {
+			count++
+		}
+		if count > limit {
+			end = i
+			break
+		}
+	}
+
+	cut := s[:end]
+	// Do not throw away more than a fifth of the allowed text to find a boundary.
+	for _, sep := range []string{"\n\n", "\n"} {
+		if i := strings.LastIndex(cut, sep); i > 0 && i >= len(cut)*4/5 {
+			return cut[:i]
+		}
+	}
+	return cut
+}
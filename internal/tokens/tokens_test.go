@@ -0,0 +1,50 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"안녕하세요", 5},
+		{"日本 語", 4},
+		{"hi 世界", 3},
+	}
+	for _, tt := range tests {
+		if got := Estimate(tt.s); got != tt.want {
+			t.Errorf("Estimate(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	paragraphs := strings.Repeat("a", 90) + "\n\n" + strings.Repeat("b", 20)
+
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"fits", "short text", 10, "short text"},
+		{"zero limit", "short text", 0, ""},
+		{"cut", strings.Repeat("a", 40), 5, strings.Repeat("a", 20)},
+		{"runes", "가나다라마바사", 3, "가나다"},
+		{"paragraph boundary", paragraphs, 25, strings.Repeat("a", 90)},
+		{"boundary too far", "aaaa\n\n" + strings.Repeat("b", 100), 25, "aaaa\n\n" + strings.Repeat("b", 94)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Truncate(tt.s, tt.limit); got != tt.want {
+				t.Errorf("Truncate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
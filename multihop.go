@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/passage"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultMaxHops = 3
+	// findingsTokenBudget bounds the excerpts of earlier hops shown to the
+	// planner when rewriting the next queries.
+	findingsTokenBudget = 6000
+)
+
+// multiHopSearch runs the search queries of the plan in hops: a query is only
+// run once the queries it depends on have been searched and crawled, and it
+// is rewritten with their findings first. The loop stops early when the
+// planner judges the findings sufficient, or when the hop limit is reached;
+// the queries left are reported as skipped.
+func (g *Server) multiHopSearch(ctx context.Context, s *Session) {
+	maxHops := g.config.MultiHop.MaxHops
+	if maxHops <= 0 {
+		maxHops = defaultMaxHops
+	}
+
+	queries := s.QueryPlan.SearchQueries
+	finished := make([]bool, len(queries))
+	var done []int
+
+	for hop := 0; ; hop++ {
+		var ready []int
+		for i, q := range queries {
+			if finished[i] {
+				continue
+			}
+			satisfied := true
+			for _, dep := range q.DependsOn {
+				if !finished[dep] {
+					satisfied = false
+					break
+				}
+			}
+			if satisfied {
+				ready = append(ready, i)
+			}
+		}
+		if len(ready) == 0 {
+			return
+		}
+
+		if hop >= maxHops {
+			log.Info().Int("hops", hop).Msg("Hop limit reached, skipping remaining queries")
+			g.skipQueries(s, finished)
+			return
+		}
+
+		if hop > 0 {
+			decision, err := queryplan.NextHop(ctx, g.models["query_planner"], s.Query, s.QueryPlan, done, ready, g.findings(s, ready))
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to rewrite queries, running them as planned")
+			} else if decision.Sufficient {
+				log.Info().Str("reason", decision.Reason).Msg("Findings are sufficient, skipping remaining queries")
+				g.skipQueries(s, finished)
+				return
+			} else {
+				for i, index := range ready {
+					rewritten := decision.SearchQueries[i]
+					if rewritten.Query == queries[index].Query {
+						continue
+					}
+					log.Info().Str("from", queries[index].Query).Str("to", rewritten.Query).Msg("Rewrote search query")
+					queries[index] = rewritten
+					s.Stream <- &Message{
+						Type:  MessageTypeQueryRewrite,
+						Index: index,
+						Text:  rewritten.Query,
+					}
+				}
+			}
+		}
+
+		g.searchQueries(ctx, s, ready)
+		g.crawlResults(s, ready, 0)
+		for _, index := range ready {
+			finished[index] = true
+		}
+		done = append(done, ready...)
+	}
+}
+
+// skipQueries reports every unfinished search query as skipped.
+func (g *Server) skipQueries(s *Session, finished []bool) {
+	for i := range finished {
+		if finished[i] {
+			continue
+		}
+		s.Stream <- &Message{
+			Type:    MessageTypeSearchDone,
+			Success: false,
+			Skipped: true,
+			Index:   i,
+		}
+	}
+}
+
+// findings returns excerpts of the pages crawled for the dependencies of the
+// search queries with the given indexes, selected for what those
+// dependencies were meant to find.
+func (g *Server) findings(s *Session, indexes []int) string {
+	var urls []string
+	var retrieval []string = []string{s.Query}
+	seen := make(map[int]bool)
+	for _, index := range indexes {
+		for _, dep := range s.QueryPlan.SearchQueries[index].DependsOn {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			retrieval = append(retrieval, s.QueryPlan.SearchQueries[dep].Query+" "+s.QueryPlan.SearchQueries[dep].Description)
+			for _, result := range s.RerankedResults[dep] {
+				urls = append(urls, result.URL)
+			}
+		}
+	}
+
+	documents := passage.Select(g.documents(s, urls), retrieval, findingsTokenBudget, g.config.GeneratorConfigs.PassageTokens)
+
+	var sb strings.Builder
+	for _, doc := range documents {
+		var text strings.Builder
+		for _, part := range doc.Contents {
+			if t, ok := part.(llm.Text); ok {
+				text.WriteString(string(t))
+			}
+		}
+		if text.Len() == 0 {
+			continue
+		}
+		sb.WriteString("<document source=\"" + doc.Source + "\">\n")
+		sb.WriteString(text.String())
+		sb.WriteString("\n</document>\n")
+	}
+	return sb.String()
+}
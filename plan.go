@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+	"github.com/rs/zerolog/log"
+)
+
+// planEditTimeout is how long a plan-only session waits for its plan before
+// it is closed.
+const planEditTimeout = 15 * time.Minute
+
+// awaitPlan pauses a plan-only session until an edited plan is submitted with
+// planAPI, and makes it the plan of the session. It reports false if no plan
+// was submitted in time.
+//
+// The session starts waiting for the plan when the paused plan is sent (see
+// planQuery), as the client may submit it before awaitPlan is reached.
+func (g *Server) awaitPlan(s *Session) bool {
+	timer := time.NewTimer(planEditTimeout)
+	defer timer.Stop()
+
+	var plan *queryplan.QueryPlan
+	select {
+	case plan = <-s.editedPlan:
+	case <-timer.C:
+		if !s.awaitingPlan.CompareAndSwap(true, false) {
+			// A plan was submitted just in time.
+			plan = <-s.editedPlan
+			break
+		}
+		log.Info().Str("session", s.ID).Msg("No query plan submitted, closing session")
+		s.Stream <- &Message{
+			Type:  MessageTypeError,
+			Error: "timed out waiting for the query plan",
+		}
+		return false
+	}
+
+	log.Info().Str("query", s.Query).Interface("plan", plan).Msg("Using submitted query plan")
+	s.QueryPlan = plan
+	// The submitted plan replaces the planned one, whether it was cached or
+	// the fallback plan.
+	s.QueryPlanDegraded, s.QueryPlanCached = false, false
+	s.resetResults(len(plan.SearchQueries))
+
+	s.Stream <- &Message{
+		Type:      MessageTypeQueryPlan,
+		QueryPlan: plan,
+		Degraded:  s.QueryPlanDegraded,
+		Cached:    s.QueryPlanCached,
+	}
+	return true
+}
+
+// planAPI resumes a plan-only session with the submitted query plan, which
+// may add, remove or change search queries and the instruction.
+func (g *Server) planAPI(w http.ResponseWriter, r *http.Request) {
+	sessID := r.PathValue("sessID")
+	session := g.GetSession(sessID)
+	if session == nil {
+		http.Error(w, "{\"error\":\"session not found\"}", http.StatusNotFound)
+		return
+	}
+
+	var plan queryplan.QueryPlan
+	err := json.NewDecoder(r.Body).Decode(&plan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = plan.Validate(queryplan.DefaultMaxQueries)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+
+	if !session.awaitingPlan.CompareAndSwap(true, false) {
+		http.Error(w, "{\"error\":\"session is not waiting for a query plan\"}", http.StatusConflict)
+		return
+	}
+	session.editedPlan <- &plan
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{session.ID})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/plancache"
+	"github.com/lemon-mint/infofluss/internal/queryplan"
+)
+
+// TestPlanSubmittedBeforeAwait checks that a plan-only session accepts an
+// edited plan as soon as the paused plan is sent, before the worker waits
+// for it.
+func TestPlanSubmittedBeforeAwait(t *testing.T) {
+	cache, err := plancache.New(8, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("what is go", &queryplan.QueryPlan{
+		Language:      "en",
+		SearchQueries: []queryplan.SearchQueries{{Query: "go programming language"}},
+	})
+
+	g := &Server{
+		config:    &Config{},
+		sessions:  make(map[string]*Session),
+		planCache: cache,
+	}
+	s := g.NewSession("what is go")
+	s.PlanOnly = true
+
+	g.planQuery(context.Background(), s)
+	msg := <-s.Stream
+	if msg.Type != MessageTypeQueryPlan || !msg.Paused {
+		t.Fatalf("planQuery() sent %+v, want a paused query plan", msg)
+	}
+
+	body := `{"language": "en", "search_queries": [{"query": "golang"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/internal/plan/"+s.ID, strings.NewReader(body))
+	r.SetPathValue("sessID", s.ID)
+	w := httptest.NewRecorder()
+	g.planAPI(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("planAPI() = %d %s, want 200", w.Code, w.Body)
+	}
+
+	if !msg.Cached {
+		t.Fatalf("planQuery() sent %+v, want the cached plan", msg)
+	}
+	if !g.awaitPlan(s) {
+		t.Fatal("awaitPlan() = false, want the submitted plan")
+	}
+	if msg := <-s.Stream; msg.Type != MessageTypeQueryPlan || msg.Cached || s.QueryPlanCached {
+		t.Errorf("awaitPlan() sent %+v, want the submitted plan not reported as cached", msg)
+	}
+	if got := s.QueryPlan.SearchQueries[0].Query; got != "golang" {
+		t.Errorf("query plan = %q, want the submitted plan", got)
+	}
+
+	// The plan can only be submitted once.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/internal/plan/"+s.ID, strings.NewReader(body))
+	r.SetPathValue("sessID", s.ID)
+	g.planAPI(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("second planAPI() = %d, want 409", w.Code)
+	}
+}
+
+// failingModel fails every request.
+type failingModel struct{}
+
+func (failingModel) GenerateStream(ctx context.Context, chat *llm.ChatContext, input *llm.Content) *llm.StreamContent {
+	stream := make(chan llm.Segment)
+	close(stream)
+	return &llm.StreamContent{Err: errors.New("unavailable"), Stream: stream}
+}
+
+func (failingModel) Close() error { return nil }
+
+func (failingModel) Name() string { return "failing" }
+
+// TestPlanQueryFallback checks that a failed planner is replaced by the
+// fallback plan, which is reported as degraded.
+func TestPlanQueryFallback(t *testing.T) {
+	g := &Server{
+		config:   &Config{},
+		sessions: make(map[string]*Session),
+		models:   map[string]llm.Model{"query_planner": failingModel{}},
+	}
+	s := g.NewSession("What is the capital of France?")
+
+	g.planQuery(context.Background(), s)
+	msg := <-s.Stream
+	if msg.Type != MessageTypeQueryPlan || !msg.Degraded || msg.Cached {
+		t.Fatalf("planQuery() sent %+v, want a degraded query plan", msg)
+	}
+	if len(msg.QueryPlan.SearchQueries) != 2 || msg.QueryPlan.SearchQueries[1].Query != "capital France" {
+		t.Errorf("query plan = %+v, want the fallback plan", msg.QueryPlan)
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/lemon-mint/coord"
 	"github.com/lemon-mint/coord/llm"
 	"github.com/lemon-mint/coord/pconf"
 	"github.com/lemon-mint/coord/provider"
+	"github.com/lemon-mint/infofluss/internal/reranker"
 
 	_ "github.com/lemon-mint/coord/provider/aistudio"
 	_ "github.com/lemon-mint/coord/provider/anthropic"
@@ -32,6 +34,20 @@ func Connect(m Providers) (c provider.LLMClient, err error) {
 	return
 }
 
+// rerankProviderType is the provider type of HTTP rerank services with a
+// Cohere or Jina compatible /rerank endpoint. Such providers can only serve
+// the search reranker.
+const rerankProviderType = "rerank"
+
+// structuredOutputProviders are the provider types with native function
+// calling, used to constrain structured replies to a schema.
+var structuredOutputProviders = map[string]bool{
+	"aistudio":  true,
+	"anthropic": true,
+	"openai":    true,
+	"vertexai":  true,
+}
+
 func GetModel(c provider.LLMClient, name string, params Parameters) (m llm.Model, err error) {
 	config := new(llm.Config)
 	config.Temperature = &params.Temperature
@@ -48,3 +64,87 @@ func GetModel(c provider.LLMClient, name string, params Parameters) (m llm.Model
 
 	return c.NewLLM(name, config)
 }
+
+// ConnectEmbedding connects to the embedding API of a provider. Only the
+// aistudio and vertexai provider types offer embeddings.
+func ConnectEmbedding(m Providers) (c provider.EmbeddingClient, err error) {
+	switch m.Type {
+	case "aistudio":
+		c, err = coord.NewEmbeddingClient(context.Background(), "aistudio", pconf.WithAPIKey(m.APIKey))
+	case "vertexai":
+		c, err = coord.NewEmbeddingClient(context.Background(), "vertexai", pconf.WithLocation(m.Location), pconf.WithProjectID(m.ProjectID))
+	default:
+		err = fmt.Errorf("provider type %q does not support embeddings", m.Type)
+	}
+	return
+}
+
+// newReranker creates the search reranker selected by the type of its model
+// config, behind the lexical pre-filter if it is enabled.
+func (g *Server) newReranker(mc ModelConfig) (reranker.Reranker, error) {
+	lexical := &reranker.Lexical{DomainTrust: g.config.RerankerConfigs.DomainTrust}
+	if mc.Type == "lexical" {
+		return lexical, nil
+	}
+
+	r, err := g.newModelReranker(mc)
+	if err != nil {
+		return nil, err
+	}
+	if g.config.RerankerConfigs.PreFilter > 0 {
+		r = &reranker.PreFilter{
+			Filter: lexical,
+			Size:   g.config.RerankerConfigs.PreFilter,
+			Next:   r,
+		}
+	}
+	return r, nil
+}
+
+func (g *Server) newModelReranker(mc ModelConfig) (reranker.Reranker, error) {
+	for _, p := range g.config.Providers {
+		if p.Name == mc.Provider && p.Type == rerankProviderType {
+			return &reranker.API{
+				Client:  httpClient,
+				BaseURL: p.Baseurl,
+				APIKey:  p.APIKey,
+				Model:   mc.Model,
+			}, nil
+		}
+	}
+
+	switch mc.Type {
+	case "", "llm":
+		client := g.clients[mc.Provider]
+		if client == nil {
+			return nil, coord.ErrNoSuchProvider
+		}
+		m, err := GetModel(client, mc.Model, mc.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		g.models["search_reranker"] = m
+		var r reranker.Reranker = &reranker.LLM{Model: m}
+		if budget := g.config.RerankerConfigs.BatchTokens; budget >= 0 {
+			r = &reranker.Batched{Next: r, TokenBudget: budget}
+		}
+		return r, nil
+	case "embedding":
+		for _, p := range g.config.Providers {
+			if p.Name != mc.Provider {
+				continue
+			}
+			client, err := ConnectEmbedding(p)
+			if err != nil {
+				return nil, err
+			}
+			m, err := client.NewEmbedding(mc.Model, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &reranker.Embedding{Model: m}, nil
+		}
+		return nil, coord.ErrNoSuchProvider
+	}
+	return nil, fmt.Errorf("unknown reranker type: %s", mc.Type)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/htmldistill"
+	"github.com/lemon-mint/infofluss/internal/passage"
+	"github.com/lemon-mint/infofluss/internal/research"
+	"github.com/rs/zerolog/log"
+)
+
+// researchWorker runs a deep research session: several rounds of planning,
+// searching and reading that build up a notes document, followed by a
+// sectioned report written from the notes and the crawled pages.
+func (g *Server) researchWorker(s *Session) {
+	defer g.CloseSession(s.ID)
+
+	ctx := context.Background()
+	cfg := g.config.Research
+	maxRounds := cfg.MaxRounds
+	if maxRounds <= 0 {
+		maxRounds = research.DefaultMaxRounds
+	}
+	maxPages := cfg.MaxPages
+	if maxPages <= 0 {
+		maxPages = research.DefaultMaxPages
+	}
+	roundTokenBudget := cfg.RoundTokenBudget
+	if roundTokenBudget <= 0 {
+		roundTokenBudget = research.DefaultRoundTokenBudget
+	}
+
+	g.planQuery(ctx, s)
+	g.clarifyQuery(ctx, s)
+	if s.PlanOnly && !g.awaitPlan(s) {
+		return
+	}
+	var indexes []int = make([]int, len(s.QueryPlan.SearchQueries))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	for round := 0; ; round++ {
+		g.searchQueries(ctx, s, indexes)
+		if remaining := maxPages - len(s.CrawledPages); remaining > 0 {
+			g.crawlResults(s, indexes, remaining)
+		}
+
+		documents, numbers := g.researchDocuments(s, indexes)
+		retrieval := []string{s.Query}
+		for _, index := range indexes {
+			retrieval = append(retrieval, s.QueryPlan.SearchQueries[index].Query+" "+s.QueryPlan.SearchQueries[index].Description)
+		}
+		documents = passage.Select(documents, retrieval, roundTokenBudget, g.config.GeneratorConfigs.PassageTokens)
+
+		if len(documents) > 0 {
+			notes, err := research.TakeNotes(ctx, g.models["response_generator"], s.Query, s.Notes, documents, sourceNumbers(documents, numbers), cfg.NotesTokens)
+			if err != nil {
+				log.Error().Err(err).Int("round", round).Msg("Failed to take notes")
+			} else {
+				s.Notes = notes
+				s.Stream <- &Message{
+					Type:  MessageTypeResearchNotes,
+					Index: round,
+					Text:  notes,
+				}
+			}
+		}
+
+		if round+1 >= maxRounds || len(s.CrawledPages) >= maxPages {
+			break
+		}
+
+		next, err := research.NextRound(ctx, g.models["query_planner"], s.Query, s.Notes, s.QueryPlan.SearchQueries, cfg.QueriesPerRound)
+		if err != nil {
+			log.Error().Err(err).Int("round", round).Msg("Failed to plan the next research round")
+			break
+		}
+		if next.Done {
+			log.Info().Str("reason", next.Reason).Int("rounds", round+1).Msg("Research is complete")
+			break
+		}
+
+		start := len(s.QueryPlan.SearchQueries)
+		s.QueryPlan.SearchQueries = append(s.QueryPlan.SearchQueries, next.SearchQueries...)
+		s.growResults(len(next.SearchQueries))
+		indexes = indexes[:0]
+		for i := range next.SearchQueries {
+			indexes = append(indexes, start+i)
+		}
+
+		s.Stream <- &Message{
+			Type:          MessageTypeResearchRound,
+			Index:         round + 1,
+			SearchQueries: next.SearchQueries,
+		}
+	}
+
+	g.writeReport(ctx, s)
+}
+
+// researchDocuments returns the pages crawled for the search queries with the
+// given indexes that were not read in an earlier round, and assigns them the
+// next source numbers. numbers maps every source to its number.
+func (g *Server) researchDocuments(s *Session, indexes []int) ([]chat.Document, map[string]int) {
+	numbers := make(map[string]int, len(s.Sources))
+	for i, url := range s.Sources {
+		numbers[url] = i + 1
+	}
+
+	var urls []string
+	for rank := 0; ; rank++ {
+		found := false
+		for _, index := range indexes {
+			if rank >= len(s.RerankedResults[index]) {
+				continue
+			}
+			found = true
+			urls = append(urls, s.RerankedResults[index][rank].URL)
+		}
+		if !found {
+			break
+		}
+	}
+
+	var documents []chat.Document
+	for _, document := range g.documents(s, urls) {
+		if _, ok := numbers[document.Source]; ok {
+			continue
+		}
+		s.Sources = append(s.Sources, document.Source)
+		numbers[document.Source] = len(s.Sources)
+		documents = append(documents, document)
+	}
+
+	return documents, numbers
+}
+
+func sourceNumbers(documents []chat.Document, numbers map[string]int) []int {
+	indexes := make([]int, len(documents))
+	for i, document := range documents {
+		indexes[i] = numbers[document.Source]
+	}
+	return indexes
+}
+
+// writeReport streams the research report: an outline is generated from the
+// notes, and every section is written from the notes and the passages of all
+// read pages most relevant to it.
+func (g *Server) writeReport(ctx context.Context, s *Session) {
+	sectionTokenBudget := g.config.Research.SectionTokenBudget
+	if sectionTokenBudget <= 0 {
+		sectionTokenBudget = research.DefaultSectionTokenBudget
+	}
+
+	var documents []chat.Document = make([]chat.Document, 0, len(s.Sources))
+	var source map[string]string = make(map[string]string, len(s.Sources))
+	var metadata map[string]*htmldistill.PageMetadata = make(map[string]*htmldistill.PageMetadata, len(s.Sources))
+	numbers := make(map[string]int, len(s.Sources))
+	for i, url := range s.Sources {
+		page := s.CrawledPages[url]
+		documents = append(documents, g.newDocument(url, page))
+		numbers[url] = i + 1
+		source[strconv.Itoa(i+1)] = url
+		if page.Metadata != nil {
+			metadata[strconv.Itoa(i+1)] = page.Metadata
+		}
+	}
+
+	s.Stream <- &Message{
+		Type:     MessageTypeSetSource,
+		Source:   source,
+		Metadata: metadata,
+	}
+
+	outline, err := research.GenerateOutline(ctx, g.models["query_planner"], s.Query, s.Notes, g.config.Research.MaxSections)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate report outline, using a single section")
+		outline = research.FallbackOutline(s.Query)
+	}
+	s.Outline = outline
+	s.Stream <- &Message{
+		Type:    MessageTypeResearchOutline,
+		Outline: outline,
+	}
+
+	s.sendText("# " + outline.Title + "\n\n")
+	for i, section := range outline.Sections {
+		s.sendText("## " + section.Heading + "\n\n")
+
+		selected := passage.Select(documents, []string{s.Query, section.Heading + " " + section.Description}, sectionTokenBudget, g.config.GeneratorConfigs.PassageTokens)
+		response := research.WriteSection(ctx, g.models["response_generator"], s.Query, outline, i, s.Notes, selected, sourceNumbers(selected, numbers))
+		if !g.streamResponse(s, time.Now(), response) {
+			return
+		}
+
+		s.sendText("\n\n")
+	}
+
+	s.finishAnswer()
+}
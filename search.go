@@ -7,6 +7,7 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -15,6 +16,8 @@ import (
 	"github.com/lemon-mint/infofluss/internal/chat"
 	"github.com/lemon-mint/infofluss/internal/crawl"
 	"github.com/lemon-mint/infofluss/internal/htmldistill"
+	"github.com/lemon-mint/infofluss/internal/langdetect"
+	"github.com/lemon-mint/infofluss/internal/passage"
 	"github.com/lemon-mint/infofluss/internal/queryplan"
 	"github.com/lemon-mint/infofluss/internal/reranker"
 	"github.com/lemon-mint/infofluss/internal/search"
@@ -27,7 +30,23 @@ var httpClient = &http.Client{
 
 func (g *Server) searchAPI(w http.ResponseWriter, r *http.Request) {
 	type Query struct {
-		Query string `json:"query"`
+		Query    string `json:"query"`
+		MultiHop *bool  `json:"multi_hop,omitempty"`
+
+		// DeepResearch runs several research rounds and answers with a
+		// sectioned report instead of a single response.
+		DeepResearch bool `json:"deep_research,omitempty"`
+
+		// PlanOnly pauses the session after planning until a plan is
+		// submitted to /api/v1/internal/plan/{sessID}.
+		PlanOnly bool `json:"plan_only,omitempty"`
+
+		// Clarify lets the planner ask which meaning of an ambiguous query
+		// is meant; the choice is submitted to /api/v1/internal/clarify/{sessID}.
+		Clarify bool `json:"clarify,omitempty"`
+
+		// Rerank overrides the result selection options of the deployment.
+		Rerank *RerankOptions `json:"rerank,omitempty"`
 	}
 
 	var q Query
@@ -43,7 +62,19 @@ func (g *Server) searchAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	session := g.NewSession(q.Query)
-	go g.searchWorker(session)
+	session.MultiHop = g.config.MultiHop.Enabled
+	if q.MultiHop != nil {
+		session.MultiHop = *q.MultiHop
+	}
+	session.DeepResearch = q.DeepResearch
+	session.PlanOnly = q.PlanOnly
+	session.Clarify = q.Clarify
+	session.RerankOptions = g.config.RerankerConfigs.options(q.Rerank)
+	if session.DeepResearch {
+		go g.researchWorker(session)
+	} else {
+		go g.searchWorker(session)
+	}
 
 	type SessionCreated struct {
 		ID string `json:"id"`
@@ -63,138 +94,325 @@ func (g *Server) searchWorker(s *Session) {
 	}()
 
 	ctx := context.Background()
+	g.planQuery(ctx, s)
+	g.clarifyQuery(ctx, s)
+	if s.PlanOnly && !g.awaitPlan(s) {
+		return
+	}
 
-	plan, err := queryplan.GenerateQueryPlan(ctx, g.models["query_planner"], s.Query)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate query plan")
-		s.Stream <- &Message{
-			Type:  MessageTypeError,
-			Error: "failed to generate query plan",
+	if s.MultiHop && s.QueryPlan.HasDependencies() {
+		g.multiHopSearch(ctx, s)
+	} else {
+		var indexes []int = make([]int, len(s.QueryPlan.SearchQueries))
+		for i := range indexes {
+			indexes[i] = i
 		}
-		return
+		g.searchQueries(ctx, s, indexes)
+		g.crawlResults(s, indexes, 0)
+	}
+
+	g.generateResponse(ctx, s)
+}
+
+// planQuery generates the query plan of the session, falling back to a
+// deterministic plan if the planner fails, and sends it to the client.
+func (g *Server) planQuery(ctx context.Context, s *Session) {
+	s.QueryPlanDegraded, s.QueryPlanCached = false, false
+	query := s.Query
+	if s.Standalone != "" {
+		query = s.Standalone
 	}
 
-	log.Info().Str("query", s.Query).Interface("plan", plan).Msg("Generated query plan")
+	var plan *queryplan.QueryPlan
+	var err error
+	if g.planCache != nil {
+		plan, s.QueryPlanCached = g.planCache.Get(query)
+	}
+	if !s.QueryPlanCached {
+		plan, err = queryplan.GenerateQueryPlan(ctx, g.models["query_planner"], query, &queryplan.Options{
+			StructuredOutput:   g.structuredOutput(g.config.ModelConfigs.QueryPlanner),
+			AllowClarification: s.Clarify && s.OriginalQuery == "",
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate query plan, using fallback plan")
+			plan = queryplan.FallbackQueryPlan(query)
+			s.QueryPlanDegraded = true
+		} else if g.planCache != nil {
+			g.planCache.Put(query, plan)
+		}
+	}
+
+	log.Info().Str("query", query).Interface("plan", plan).Msg("Generated query plan")
 	s.QueryPlan = plan
-	s.Results = make([][]search.SearchResult, len(plan.SearchQueries))
-	s.RerankedResults = make([][]search.SearchResult, len(plan.SearchQueries))
+	s.resetResults(len(plan.SearchQueries))
 
+	paused := s.PlanOnly && plan.Clarification == nil
+	if paused {
+		// Accept an edited plan as soon as the client can see the paused one.
+		s.awaitingPlan.Store(true)
+	}
 	s.Stream <- &Message{
 		Type:      MessageTypeQueryPlan,
 		QueryPlan: plan,
+		Degraded:  s.QueryPlanDegraded,
+		Cached:    s.QueryPlanCached,
+		Paused:    paused,
 	}
+}
 
+// searchQueries runs the search queries of the plan with the given indexes in
+// parallel and reranks their results.
+func (g *Server) searchQueries(ctx context.Context, s *Session, indexes []int) {
 	wg := &sync.WaitGroup{}
-	for index, query := range plan.SearchQueries {
+	for _, index := range indexes {
 		wg.Add(1)
 		go func(index int, query queryplan.SearchQueries) {
 			defer wg.Done()
-			endpoint := g.config.SearchEndpoints[rand.IntN(len(g.config.SearchEndpoints))]
-			log.Info().Str("endpoint", endpoint).Str("query", query.Query).Msg("Searching")
-			results, err := search.SearchSearXNG(httpClient, endpoint, query.Query, g.config.SearchEngines)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to search")
-				s.Stream <- &Message{
-					Type:    MessageTypeSearchDone,
-					Success: false,
-					Index:   index,
-				}
-				return
-			}
+			g.searchQuery(ctx, s, index, query)
+		}(index, s.QueryPlan.SearchQueries[index])
+	}
+	wg.Wait()
+	log.Info().Interface("results", s.RerankedResults).Msg("Search results")
+}
 
-			s.Results[index] = results
-			var rerankInput []string = make([]string, len(results))
-			for i, result := range results {
-				type InputFormat struct {
-					URL     string `json:"url"`
-					Title   string `json:"title"`
-					Snippet string `json:"snippet"`
-				}
-				data, _ := json.Marshal(InputFormat{
-					URL:     result.URL,
-					Title:   result.Title,
-					Snippet: result.Content,
-				})
-				rerankInput[i] = string(data)
-			}
+func (g *Server) searchQuery(ctx context.Context, s *Session, index int, query queryplan.SearchQueries) {
+	endpoint := g.config.SearchEndpoints[rand.IntN(len(g.config.SearchEndpoints))]
+	log.Info().Str("endpoint", endpoint).Str("query", query.Query).Msg("Searching")
+	results, err := search.SearchSearXNG(httpClient, endpoint, query.Query, g.config.SearchEngines)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search")
+		s.Stream <- &Message{
+			Type:    MessageTypeSearchDone,
+			Success: false,
+			Index:   index,
+		}
+		return
+	}
 
-			reranked, err := reranker.RerankDocuments(ctx, g.models["search_reranker"], rerankInput, query.Query+"\n\n"+query.Description)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to rerank documents")
-				s.Stream <- &Message{
-					Type:    MessageTypeSearchDone,
-					Success: false,
-					Index:   index,
-				}
-				return
-			}
+	s.Results[index] = results
+	var candidates []reranker.Candidate = make([]reranker.Candidate, len(results))
+	for i, result := range results {
+		candidates[i] = reranker.Candidate{
+			URL:     result.URL,
+			Title:   result.Title,
+			Snippet: result.Content,
+			Engines: result.Engines,
 
-			var rerankedResults []search.SearchResult = make([]search.SearchResult, len(reranked))
-			for i, rerankedIndex := range reranked {
-				rerankedResults[i] = s.Results[index][rerankedIndex]
-			}
-			s.RerankedResults[index] = rerankedResults
+			PublishedDate: result.PublishedDate,
+		}
+	}
 
-			s.Stream <- &Message{
-				Type:    MessageTypeSearchDone,
-				Success: true,
-				Index:   index,
-			}
-		}(index, query)
+	reranked, err := reranker.Select(ctx, g.reranker, candidates, query.Query+"\n\n"+query.Description, s.RerankOptions)
+	if err != nil {
+		log.Error().Err(err).Str("query", query.Query).Str("fallback", g.config.RerankerConfigs.Fallback).Msg("Failed to rerank documents, using fallback order")
+		reranked = reranker.FallbackOrder(candidates, query.Query+" "+query.Description, g.config.RerankerConfigs.Fallback, g.config.RerankerConfigs.DomainTrust, len(candidates))
+		reranked = reranker.Finish(candidates, reranked, s.RerankOptions)
+		s.RerankFallback[index] = true
 	}
-	wg.Wait()
-	log.Info().Interface("results", s.RerankedResults).Msg("Search results")
 
+	var rerankedResults []search.SearchResult = make([]search.SearchResult, len(reranked))
+	for i, r := range reranked {
+		rerankedResults[i] = s.Results[index][r.Index]
+		if r.Scored {
+			score := r.Score
+			rerankedResults[i].Score = &score
+		}
+		rerankedResults[i].Rationale = r.Rationale
+	}
+	s.RerankedResults[index] = rerankedResults
+
+	s.Stream <- &Message{
+		Type:     MessageTypeSearchDone,
+		Success:  true,
+		Fallback: s.RerankFallback[index],
+		Index:    index,
+		Results:  rerankedResults,
+	}
+}
+
+// crawlResults crawls the reranked results of the search queries with the
+// given indexes. Pages that were already crawled for the session are skipped.
+// If limit is positive, at most limit results are crawled, best ranked first;
+// links followed from hub pages do not count against the limit.
+func (g *Server) crawlResults(s *Session, indexes []int, limit int) {
 	var deduplicate map[string]struct{} = make(map[string]struct{})
-	for _, results := range s.RerankedResults {
-		for _, result := range results {
-			deduplicate[result.URL] = struct{}{}
+	for url := range s.CrawledPages {
+		deduplicate[url] = struct{}{}
+	}
+
+	var urls []string
+	for rank := 0; ; rank++ {
+		found := false
+		for _, index := range indexes {
+			if rank >= len(s.RerankedResults[index]) {
+				continue
+			}
+			found = true
+			url := s.RerankedResults[index][rank].URL
+			if _, ok := deduplicate[url]; ok {
+				continue
+			}
+			deduplicate[url] = struct{}{}
+			urls = append(urls, url)
 		}
+		if !found {
+			break
+		}
+	}
+	if limit > 0 && len(urls) > limit {
+		urls = urls[:limit]
 	}
 
-	wg = &sync.WaitGroup{}
+	wg := &sync.WaitGroup{}
 	var crawlMu sync.Mutex
-	for url := range deduplicate {
-		wg.Add(1)
-		go func(url string) {
-			defer wg.Done()
-			contents, err := g.CrawlPage(url)
-			if err != nil {
-				log.Error().Err(err).Str("url", url).Msg("Failed to crawl page")
-				return
-			}
+	var crawlWorker func(url, parent string)
+	crawlWorker = func(url, parent string) {
+		defer wg.Done()
+		page, err := g.CrawlPage(url)
+		if err != nil {
+			log.Error().Err(err).Str("url", url).Msg("Failed to crawl page")
+			return
+		}
+		page.Parent = parent
 
-			s.Stream <- &Message{
-				Type: MessageTypeCrawlDone,
-				URL:  url,
-			}
+		s.Stream <- &Message{
+			Type: MessageTypeCrawlDone,
+			URL:  url,
+		}
+		crawlMu.Lock()
+		s.CrawledPages[url] = page
+		crawlMu.Unlock()
+
+		// Follow links one hop deep only, and only from hub pages.
+		if parent != "" || !g.config.CrawlerConfigs.FollowLinks || page.Inventory == nil || !page.Inventory.IsHub() {
+			return
+		}
+
+		for _, link := range selectLinks(page.Inventory, passage.Queries(s.Query, s.QueryPlan), g.config.CrawlerConfigs.MaxFollowLinks) {
 			crawlMu.Lock()
-			s.CrawledPages[url] = contents
+			_, claimed := deduplicate[link]
+			if !claimed {
+				deduplicate[link] = struct{}{}
+				page.Followed = append(page.Followed, link)
+			}
 			crawlMu.Unlock()
-		}(url)
+			if claimed {
+				continue
+			}
+
+			log.Info().Str("url", link).Str("parent", url).Msg("Following link from hub page")
+			wg.Add(1)
+			go crawlWorker(link, url)
+		}
+	}
+	for _, url := range urls {
+		wg.Add(1)
+		go crawlWorker(url, "")
 	}
 	wg.Wait()
+}
 
-	var documents []chat.Document = make([]chat.Document, 0, len(s.CrawledPages))
-	var source map[string]string = make(map[string]string, len(s.CrawledPages))
-	var i int = 0
-	for url, crawledPage := range s.CrawledPages {
-		i++
-		documents = append(documents, chat.Document{
-			Source:   url,
-			Contents: crawledPage,
-		})
-		source[strconv.Itoa(i)] = url
+// documents returns the crawled pages of the given URLs as documents, each
+// page followed by the pages that were followed from it.
+func (g *Server) documents(s *Session, urls []string) []chat.Document {
+	var documents []chat.Document = make([]chat.Document, 0, len(urls))
+	seen := make(map[string]bool, len(urls))
+	add := func(url string) {
+		crawledPage, ok := s.CrawledPages[url]
+		if !ok || seen[url] {
+			return
+		}
+		seen[url] = true
+		documents = append(documents, g.newDocument(url, crawledPage))
+	}
+	for _, url := range urls {
+		add(url)
+		if crawledPage, ok := s.CrawledPages[url]; ok {
+			for _, followed := range crawledPage.Followed {
+				add(followed)
+			}
+		}
+	}
+	return documents
+}
+
+func (g *Server) generateResponse(ctx context.Context, s *Session) {
+	documents := g.prepareDocuments(ctx, s, s.rankedURLs())
+
+	response := chat.Generate(ctx, g.models["response_generator"], s.Query, s.QueryPlan, documents)
+	if !g.streamResponse(s, time.Now(), response) {
+		return
+	}
+
+	s.finishAnswer()
+}
+
+// prepareDocuments turns the crawled pages of urls into the documents given to
+// the generator, fitted into the token budget, and sends their sources to
+// the client.
+func (g *Server) prepareDocuments(ctx context.Context, s *Session, urls []string) []chat.Document {
+	documents := g.documents(s, urls)
+
+	if g.config.GeneratorConfigs.ContentRerank.Enabled {
+		documents = g.rerankContents(ctx, s, documents)
+	}
+
+	if g.config.GeneratorConfigs.PassageRetrieval {
+		documents = passage.Select(documents, passage.Queries(s.Query, s.QueryPlan), g.config.GeneratorConfigs.DocumentTokenBudget, g.config.GeneratorConfigs.PassageTokens)
+	}
+
+	documents, s.PackReports = chat.Pack(documents, g.config.GeneratorConfigs.DocumentTokenBudget)
+	for _, report := range s.PackReports {
+		if report.Status != chat.PackStatusKept {
+			log.Info().Str("url", report.Source).Str("status", string(report.Status)).Int("tokens", report.Tokens).Int("kept_tokens", report.KeptTokens).Msg("Packed document")
+		}
+	}
+
+	var source map[string]string = make(map[string]string, len(documents))
+	var metadata map[string]*htmldistill.PageMetadata = make(map[string]*htmldistill.PageMetadata, len(documents))
+	s.Sources = make([]string, len(documents))
+	for i, document := range documents {
+		source[strconv.Itoa(i+1)] = document.Source
+		if document.Metadata != nil {
+			metadata[strconv.Itoa(i+1)] = document.Metadata
+		}
+		s.Sources[i] = document.Source
 	}
 
 	s.Stream <- &Message{
-		Type:   MessageTypeSetSource,
-		Source: source,
+		Type:     MessageTypeSetSource,
+		Source:   source,
+		Metadata: metadata,
 	}
+	return documents
+}
 
-	t := time.Now()
-	response := chat.Generate(ctx, g.models["response_generator"], s.Query, s.QueryPlan, documents)
+// rerankContents ranks the crawled documents by their content against the
+// query plan, see reranker.RerankContents.
+func (g *Server) rerankContents(ctx context.Context, s *Session, documents []chat.Document) []chat.Document {
+	c := g.config.GeneratorConfigs.ContentRerank
+	query := strings.Join(passage.Queries(s.Query, s.QueryPlan), "\n")
+	documents, reports, err := reranker.RerankContents(ctx, g.reranker, documents, query, reranker.ContentOptions{
+		ExcerptTokens: c.ExcerptTokens,
+		MinTokens:     c.MinTokens,
+		MinScore:      c.MinScore,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to rerank crawled pages, keeping their order")
+	}
+	s.ContentReports = reports
+	for _, report := range reports {
+		if report.Status != reranker.ContentStatusKept {
+			log.Info().Str("url", report.Source).Str("status", string(report.Status)).Msg("Dropped crawled page")
+		}
+	}
+	return documents
+}
 
+// streamResponse forwards the text of a model response to the session
+// stream. It reports whether the response completed; on failure the client
+// is sent an error.
+func (g *Server) streamResponse(s *Session, t time.Time, response *llm.StreamContent) bool {
 	var t_first_token time.Duration
 	for part := range response.Stream {
 		if t_first_token == 0 {
@@ -202,10 +420,7 @@ func (g *Server) searchWorker(s *Session) {
 		}
 
 		if part.Type() == llm.SegmentTypeText {
-			s.Stream <- &Message{
-				Type: MessageTypeGenerateStream,
-				Text: string(part.(llm.Text)),
-			}
+			s.sendText(string(part.(llm.Text)))
 		}
 	}
 	var t_total time.Duration = time.Since(t)
@@ -220,7 +435,7 @@ func (g *Server) searchWorker(s *Session) {
 			Type:  MessageTypeError,
 			Error: "failed to generate response",
 		}
-		return
+		return false
 	}
 
 	if response.UsageData != nil {
@@ -235,12 +450,10 @@ func (g *Server) searchWorker(s *Session) {
 			Msg("Generated response")
 	}
 
-	s.Stream <- &Message{
-		Type: MessageTypeGenerateStreamDone,
-	}
+	return true
 }
 
-func (g *Server) CrawlPage(url string) ([]llm.Segment, error) {
+func (g *Server) CrawlPage(url string) (*CrawledPage, error) {
 	var rawhtml string
 	var err error
 
@@ -258,7 +471,7 @@ func (g *Server) CrawlPage(url string) ([]llm.Segment, error) {
 		for _, image := range images {
 			parts = append(parts, &image)
 		}
-		return parts, nil
+		return &CrawledPage{Contents: parts}, nil
 	} else if g.config.CrawlerConfigs.Mode == "cdp_images" {
 		images, err := crawl.ScrapeCDPImages(url)
 		if err != nil {
@@ -268,7 +481,7 @@ func (g *Server) CrawlPage(url string) ([]llm.Segment, error) {
 		for _, image := range images {
 			parts = append(parts, &image)
 		}
-		return parts, nil
+		return &CrawledPage{Contents: parts}, nil
 	} else if g.config.CrawlerConfigs.Mode == "http" {
 		rawhtml, err = crawl.ScrapeHTTP(httpClient, url)
 		if err != nil {
@@ -278,7 +491,27 @@ func (g *Server) CrawlPage(url string) ([]llm.Segment, error) {
 		return nil, fmt.Errorf("unknown crawler mode: %s", g.config.CrawlerConfigs.Mode)
 	}
 
-	cleaned, err := htmldistill.Clean(rawhtml)
+	// Parse the page once: metadata and links are read from the document
+	// before distillation rewrites it.
+	doc, err := htmldistill.Parse(rawhtml)
+	if err != nil {
+		return nil, err
+	}
+	metadata := htmldistill.ExtractMetadataNode(doc, url)
+	inventory := htmldistill.ExtractInventoryNode(doc, url)
+
+	var cleaned string
+	switch g.documentFormat() {
+	case chat.DocumentFormatMarkdown:
+		cleaned = htmldistill.ToMarkdownNode(doc)
+	case chat.DocumentFormatText:
+		cleaned, err = htmldistill.CleanNode(doc)
+		if err == nil {
+			cleaned, err = htmldistill.ExtractText(cleaned)
+		}
+	default:
+		cleaned, err = htmldistill.CleanNode(doc)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -287,5 +520,60 @@ func (g *Server) CrawlPage(url string) ([]llm.Segment, error) {
 		return nil, fmt.Errorf("utf8 validation failed")
 	}
 
-	return []llm.Segment{llm.Text(cleaned)}, nil
+	// The declared language is only a fallback, as many sites keep the
+	// default lang attribute of their template.
+	text := cleaned
+	if g.documentFormat() == chat.DocumentFormatHTML {
+		text, _ = htmldistill.ExtractText(cleaned)
+	}
+	if language := langdetect.Detect(text); language != "" {
+		metadata.Language = language
+	}
+
+	return &CrawledPage{
+		Contents:  []llm.Segment{llm.Text(cleaned)},
+		Metadata:  metadata,
+		Inventory: inventory,
+	}, nil
+}
+
+// structuredOutput reports whether m should be asked for replies constrained
+// to a schema.
+func (g *Server) structuredOutput(m ModelConfig) bool {
+	if m.StructuredOutput != nil {
+		return *m.StructuredOutput
+	}
+	for _, p := range g.config.Providers {
+		if p.Name == m.Provider {
+			return structuredOutputProviders[p.Type]
+		}
+	}
+	return false
+}
+
+func (g *Server) newDocument(url string, page *CrawledPage) chat.Document {
+	return chat.Document{
+		Source:   url,
+		Format:   g.documentFormat(),
+		Language: page.Language(),
+		Metadata: page.Metadata,
+		Contents: page.Contents,
+	}
+}
+
+// documentFormat returns the format of the documents produced by CrawlPage.
+// Image based crawler modes have no textual format.
+func (g *Server) documentFormat() chat.DocumentFormat {
+	switch g.config.CrawlerConfigs.Mode {
+	case "cdp_pdf", "cdp_images":
+		return ""
+	}
+
+	switch chat.DocumentFormat(g.config.CrawlerConfigs.DocumentFormat) {
+	case chat.DocumentFormatMarkdown:
+		return chat.DocumentFormatMarkdown
+	case chat.DocumentFormatText:
+		return chat.DocumentFormatText
+	}
+	return chat.DocumentFormatHTML
 }
@@ -5,10 +5,13 @@ import (
 	"io/fs"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/lemon-mint/coord"
 	"github.com/lemon-mint/coord/llm"
 	"github.com/lemon-mint/coord/provider"
+	"github.com/lemon-mint/infofluss/internal/plancache"
+	"github.com/lemon-mint/infofluss/internal/reranker"
 )
 
 type Server struct {
@@ -19,7 +22,11 @@ type Server struct {
 	config  *Config
 
 	sessions      map[string]*Session
+	conversations map[string]*conversation // finished sessions, see keepConversation
 	sessionsMutex sync.Mutex
+
+	planCache *plancache.Cache // nil if disabled
+	reranker  reranker.Reranker
 }
 
 //go:embed frontend/dist/*
@@ -41,14 +48,18 @@ func NewServer(c *Config) (*Server, error) {
 	var err error
 
 	s := &Server{
-		mux:      http.NewServeMux(),
-		clients:  make(map[string]provider.LLMClient),
-		models:   make(map[string]llm.Model),
-		sessions: make(map[string]*Session),
-		config:   c,
+		mux:           http.NewServeMux(),
+		clients:       make(map[string]provider.LLMClient),
+		models:        make(map[string]llm.Model),
+		sessions:      make(map[string]*Session),
+		conversations: make(map[string]*conversation),
+		config:        c,
 	}
 
 	for _, m := range c.Providers {
+		if m.Type == rerankProviderType {
+			continue
+		}
 		c, err := Connect(m)
 		if err != nil {
 			return nil, err
@@ -74,11 +85,17 @@ func NewServer(c *Config) (*Server, error) {
 	}
 	s.models["chat"], err = GetModel(chat_client, c.ModelConfigs.Chat.Model, c.ModelConfigs.Chat.Parameters)
 
-	search_reranker_client := s.clients[c.ModelConfigs.SearchReranker.Provider]
-	if search_reranker_client == nil {
-		return nil, coord.ErrNoSuchProvider
+	s.reranker, err = s.newReranker(c.ModelConfigs.SearchReranker)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.PlanCache.Enabled {
+		s.planCache, err = plancache.New(c.PlanCache.Size, time.Duration(c.PlanCache.TTLSeconds)*time.Second, c.PlanCache.Dir)
+		if err != nil {
+			return nil, err
+		}
 	}
-	s.models["search_reranker"], err = GetModel(search_reranker_client, c.ModelConfigs.SearchReranker.Model, c.ModelConfigs.SearchReranker.Parameters)
 
 	static, err := fs.Sub(frontend, "frontend/dist")
 	if err != nil {
@@ -88,6 +105,9 @@ func NewServer(c *Config) (*Server, error) {
 	s.mux.Handle("/", http.FileServer(http.FS(&svelteFS{static})))
 	s.mux.HandleFunc("/api/v1/internal/search", s.searchAPI)
 	s.mux.HandleFunc("/api/v1/internal/stream/{sessID}", s.sessionSSE)
+	s.mux.HandleFunc("POST /api/v1/internal/plan/{sessID}", s.planAPI)
+	s.mux.HandleFunc("POST /api/v1/internal/clarify/{sessID}", s.clarifyAPI)
+	s.mux.HandleFunc("POST /api/v1/internal/followup/{sessID}", s.followUpAPI)
 
 	return s, nil
 }
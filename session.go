@@ -6,25 +6,79 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/lemon-mint/coord/llm"
+	"github.com/lemon-mint/infofluss/internal/chat"
+	"github.com/lemon-mint/infofluss/internal/citation"
+	"github.com/lemon-mint/infofluss/internal/htmldistill"
 	"github.com/lemon-mint/infofluss/internal/queryplan"
+	"github.com/lemon-mint/infofluss/internal/reranker"
+	"github.com/lemon-mint/infofluss/internal/research"
 	"github.com/lemon-mint/infofluss/internal/search"
 )
 
 type Session struct {
 	ID string
 
-	Query           string
-	QueryPlan       *queryplan.QueryPlan
-	Results         [][]search.SearchResult
-	RerankedResults [][]search.SearchResult
-	CrawledPages    map[string][]llm.Segment
+	Query             string
+	QueryPlan         *queryplan.QueryPlan
+	QueryPlanDegraded bool   // the planner failed and QueryPlan comes from queryplan.FallbackQueryPlan
+	QueryPlanCached   bool   // QueryPlan was reused from the plan cache
+	MultiHop          bool   // run dependent search queries in hops, see multiHopSearch
+	DeepResearch      bool   // run research rounds and write a report, see researchWorker
+	PlanOnly          bool   // pause after planning until an edited plan is submitted
+	Clarify           bool   // let the planner ask which meaning of an ambiguous query is meant
+	OriginalQuery     string // the query before it was replaced by a clarification choice
+	Results           [][]search.SearchResult
+	RerankedResults   [][]search.SearchResult
+	RerankFallback    []bool            // the results of the search query were ranked without the reranker
+	RerankOptions     *reranker.Options // selection of the results to crawl
+	CrawledPages      map[string]*CrawledPage
+	ContentReports    []reranker.ContentReport
+	PackReports       []chat.PackReport
+	Sources           []string // URLs of the pages given to the generator, cited by position (starting at 1)
+	CitationReport    *citation.Report
+
+	// Conversation state, see followUpWorker.
+	History    []chat.Turn // earlier turns of the conversation, oldest first
+	Standalone string      // the follow-up rewritten to stand on its own, planned instead of Query
+
+	// Deep research state.
+	Notes   string            // running research notes
+	Outline *research.Outline // outline of the report
 
 	Error error
 
 	Stream chan *Message
+
+	awaitingPlan atomic.Bool
+	editedPlan   chan *queryplan.QueryPlan
+
+	awaitingChoice atomic.Bool
+	choice         chan string
+
+	answer    strings.Builder  // text streamed to the client, see sendText
+	citations *citation.Parser // citations of the answer, against Sources
+}
+
+type CrawledPage struct {
+	Contents  []llm.Segment
+	Metadata  *htmldistill.PageMetadata
+	Inventory *htmldistill.Inventory
+
+	Parent   string   // URL of the hub page this page was followed from
+	Followed []string // URLs of the pages followed from this page
+}
+
+// Language returns the detected language of the page, if known.
+func (p *CrawledPage) Language() string {
+	if p.Metadata == nil {
+		return ""
+	}
+	return p.Metadata.Language
 }
 
 type MessageType int16
@@ -40,19 +94,131 @@ const (
 	MessageTypeCrawlDone          MessageType = 7
 	MessageTypeSetSource          MessageType = 8
 	MessageTypeDisconnect         MessageType = 9
+	MessageTypeQueryRewrite       MessageType = 10
+	MessageTypeResearchRound      MessageType = 11
+	MessageTypeResearchNotes      MessageType = 12
+	MessageTypeResearchOutline    MessageType = 13
+	MessageTypeClarification      MessageType = 14
+	MessageTypeCitation           MessageType = 15
+	MessageTypeUncitedClaim       MessageType = 16
 )
 
 type Message struct {
 	Type      MessageType          `json:"type"`
 	QueryPlan *queryplan.QueryPlan `json:"query_plan,omitempty"`
+	Degraded  bool                 `json:"degraded,omitempty"` // MessageTypeQueryPlan
+	Cached    bool                 `json:"cached,omitempty"`   // MessageTypeQueryPlan
+	Paused    bool                 `json:"paused,omitempty"`   // MessageTypeQueryPlan, waiting for the plan to be submitted
 
-	Success bool   `json:"success,omitempty"`
-	Index   int    `json:"index,omitempty"` // MessageTypeSearchDone
-	Text    string `json:"text,omitempty"`  // MessageTypeGenerateStream
-	URL     string `json:"url,omitempty"`   // MessageTypeCrawlDone
-	Error   string `json:"error,omitempty"` // MessageTypeGenerateStreamDone
+	Success  bool   `json:"success,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"`  // MessageTypeSearchDone
+	Fallback bool   `json:"fallback,omitempty"` // MessageTypeSearchDone, the reranker failed and a fallback order was used
+	Index    int    `json:"index,omitempty"`    // MessageTypeSearchDone, MessageTypeQueryRewrite, research round
+	Text     string `json:"text,omitempty"`     // MessageTypeGenerateStream, MessageTypeQueryRewrite, MessageTypeResearchNotes
+	URL      string `json:"url,omitempty"`      // MessageTypeCrawlDone
+	Error    string `json:"error,omitempty"`    // MessageTypeGenerateStreamDone
 
-	Source map[string]string `json:"source,omitempty"` // MessageTypeSetSource
+	Source   map[string]string                    `json:"source,omitempty"`   // MessageTypeSetSource
+	Metadata map[string]*htmldistill.PageMetadata `json:"metadata,omitempty"` // MessageTypeSetSource
+
+	Results       []search.SearchResult     `json:"results,omitempty"`        // MessageTypeSearchDone, the selected results with their scores
+	SearchQueries []queryplan.SearchQueries `json:"search_queries,omitempty"` // MessageTypeResearchRound, appended to the plan
+	Outline       *research.Outline         `json:"outline,omitempty"`        // MessageTypeResearchOutline
+
+	Clarification *queryplan.Clarification `json:"clarification,omitempty"` // MessageTypeClarification
+
+	Citations      []citation.Citation `json:"citations,omitempty"`       // MessageTypeCitation
+	Claims         []citation.Claim    `json:"claims,omitempty"`          // MessageTypeUncitedClaim, statements without a valid citation
+	CitationReport *citation.Report    `json:"citation_report,omitempty"` // MessageTypeGenerateStreamDone
+}
+
+// sendText streams a part of the answer to the client, followed by the
+// citations and uncited claims it completes.
+func (s *Session) sendText(text string) {
+	if s.citations == nil {
+		s.citations = citation.NewParser(len(s.Sources))
+	}
+	s.answer.WriteString(text)
+	s.Stream <- &Message{
+		Type: MessageTypeGenerateStream,
+		Text: text,
+	}
+	s.sendCitations(s.citations.Write(text))
+}
+
+// finishAnswer ends the answer: it checks the citations of its end and sends
+// GenerateStreamDone with the citation report.
+func (s *Session) finishAnswer() {
+	if s.citations == nil {
+		s.citations = citation.NewParser(len(s.Sources))
+	}
+	citations, claims, report := s.citations.Close()
+	s.sendCitations(citations, claims)
+	s.CitationReport = report
+	s.Stream <- &Message{
+		Type:           MessageTypeGenerateStreamDone,
+		CitationReport: report,
+	}
+}
+
+func (s *Session) sendCitations(citations []citation.Citation, claims []citation.Claim) {
+	if len(citations) > 0 {
+		s.Stream <- &Message{
+			Type:      MessageTypeCitation,
+			Citations: citations,
+		}
+	}
+	if len(claims) > 0 {
+		s.Stream <- &Message{
+			Type:   MessageTypeUncitedClaim,
+			Claims: claims,
+		}
+	}
+}
+
+// Answer returns the text streamed to the client so far.
+func (s *Session) Answer() string {
+	return s.answer.String()
+}
+
+// resetResults discards the results of all search queries and makes room for
+// the results of n search queries.
+func (s *Session) resetResults(n int) {
+	s.Results = make([][]search.SearchResult, n)
+	s.RerankedResults = make([][]search.SearchResult, n)
+	s.RerankFallback = make([]bool, n)
+}
+
+// growResults makes room for the results of n more search queries.
+func (s *Session) growResults(n int) {
+	s.Results = append(s.Results, make([][]search.SearchResult, n)...)
+	s.RerankedResults = append(s.RerankedResults, make([][]search.SearchResult, n)...)
+	s.RerankFallback = append(s.RerankFallback, make([]bool, n)...)
+}
+
+// rankedURLs returns the URLs of the reranked results ordered by rerank
+// position: the best result of every search query first, then the second
+// best, and so on. Duplicates are removed.
+func (s *Session) rankedURLs() []string {
+	var urls []string
+	seen := make(map[string]struct{})
+	for rank := 0; ; rank++ {
+		found := false
+		for _, results := range s.RerankedResults {
+			if rank >= len(results) {
+				continue
+			}
+			found = true
+			if _, ok := seen[results[rank].URL]; ok {
+				continue
+			}
+			seen[results[rank].URL] = struct{}{}
+			urls = append(urls, results[rank].URL)
+		}
+		if !found {
+			return urls
+		}
+	}
 }
 
 func (g *Server) GetSession(id string) *Session {
@@ -70,6 +236,7 @@ func (g *Server) CloseSession(id string) {
 			defer recover() // ignore double close panic
 			close(s.Stream)
 		}()
+		g.keepConversation(s)
 	}
 	delete(g.sessions, id)
 }
@@ -87,10 +254,13 @@ func (g *Server) NewSession(query string) *Session {
 	g.sessionsMutex.Lock()
 	defer g.sessionsMutex.Unlock()
 	s := &Session{
-		ID:           newSessionID(),
-		Query:        query,
-		Stream:       make(chan *Message, 128),
-		CrawledPages: map[string][]llm.Segment{},
+		ID:            newSessionID(),
+		Query:         query,
+		Stream:        make(chan *Message, 128),
+		CrawledPages:  map[string]*CrawledPage{},
+		RerankOptions: g.config.RerankerConfigs.options(nil),
+		editedPlan:    make(chan *queryplan.QueryPlan, 1),
+		choice:        make(chan string, 1),
 	}
 	g.sessions[s.ID] = s
 	return s